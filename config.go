@@ -11,6 +11,66 @@ type Config struct {
 	// If nil, the default resolver will be used, and Encoded types must be registered with encs.Register()
 	Resolver encodable.Resolver
 
+	// IncludeUnexported will include unexported struct fields in the encoded data.
+	IncludeUnexported bool
+
+	// ChanPolicy, FuncPolicy and UnsafePointerPolicy are how channels, funcs and unsafe.Pointers are handled;
+	// by default, encoding a type that holds them returns an error. See encodable.KindPolicy.
+	// They must be the same for Encoder and Decoder.
+	ChanPolicy          encodable.KindPolicy
+	FuncPolicy          encodable.KindPolicy
+	UnsafePointerPolicy encodable.KindPolicy
+
+	// Canonical makes encoded data deterministic; see encodable.Config.Canonical.
+	Canonical bool
+
+	// TreeShaped encodes pointers and interfaces without tracking references, for values that never share pointers; see encodable.Config.TreeShaped.
+	TreeShaped bool
+
+	// Aliasing preserves sharing of slices and maps, as is always done for pointers; see encodable.Config.Aliasing.
+	Aliasing bool
+
+	// Merge makes the Decoder merge decoded values into the value it is given, instead of replacing it;
+	// map entries are added, slice elements are appended, and omitted struct members are kept. See encodable.Config.Merge.
+	// It only affects the Decoder, and has no effect with Delta.
+	Merge bool
+
+	// OmitZero leaves struct members that are zero out of the encoded data, writing a bitmap of those that are present; see encodable.Config.OmitZero.
+	OmitZero bool
+
+	// PackBits writes bools in structs, slices and arrays as single bits; see encodable.Config.PackBits.
+	PackBits bool
+
+	// PersistReferences keeps the reference table of Encoders and Decoders across messages,
+	// so a value sent through a pointer in one message is sent as a reference to it in later messages, and decodes to the same value.
+	// Values are only sent once, and changes to them after they have been sent are not seen by the Decoder.
+	// Encoder.Reset and Decoder.Reset empty the table, and must be called at the same point in the stream; see encodable.References.
+	// PersistReferences must be set for both Encoder and Decoder, or neither.
+	PersistReferences bool
+
+	// ReferenceLimit, if greater than zero, empties the persistent reference table before a message once it holds more than ReferenceLimit values.
+	// It bounds the memory kept between messages, but not within one. It must be the same for Encoder and Decoder.
+	ReferenceLimit int
+
+	// Delta keeps the last value of each type sent by the Encoder or received by the Decoder,
+	// and sends later values of the type as the changes from it, written by encodable.EncodeDelta;
+	// struct members, array and slice elements and map entries that haven't changed aren't sent.
+	// The first value of each type is sent in full. After an error, both Encoder and Decoder must be Reset.
+	// Delta must be set for both Encoder and Decoder, or neither.
+	Delta bool
+
+	// Header makes the Encoder write a stream header before its first message, and the Decoder read and check one before its first message.
+	// The header holds the wire format Version and a fingerprint of the Config,
+	// so a Decoder can return an encio.ErrBadVersion or encio.ErrBadConfig error instead of decoding garbage.
+	// Header must be set for both Encoder and Decoder, or neither.
+	Header bool
+
+	// Fingerprint makes the Encoder write a fingerprint of the type's Encodable in every message, and the Decoder check it.
+	// The fingerprint is a hash of the Encodable's String, so it changes with the Config and with the layout of the type;
+	// a Decoder with a different IncludeUnexported setting, or a struct that has gained or lost fields, returns an encio.ErrBadConfig error.
+	// It costs 8 bytes per message. Fingerprint must be set for both Encoder and Decoder, or neither.
+	Fingerprint bool
+
 	//TODO: add more
 }
 
@@ -26,3 +86,26 @@ func (c *Config) copyAndFill() *Config {
 
 	return config
 }
+
+// encodableConfig returns the encodable.Config for the Encodables of Encoders and Decoders.
+// c must have been filled with copyAndFill.
+// If PersistReferences is set, it has a new References.
+func (c *Config) encodableConfig() *encodable.Config {
+	ec := &encodable.Config{
+		Resolver:            c.Resolver,
+		IncludeUnexported:   c.IncludeUnexported,
+		ChanPolicy:          c.ChanPolicy,
+		FuncPolicy:          c.FuncPolicy,
+		UnsafePointerPolicy: c.UnsafePointerPolicy,
+		Canonical:           c.Canonical,
+		TreeShaped:          c.TreeShaped,
+		Aliasing:            c.Aliasing,
+		OmitZero:            c.OmitZero,
+		PackBits:            c.PackBits,
+		Merge:               c.Merge && !c.Delta,
+	}
+	if c.PersistReferences {
+		ec.References = encodable.NewReferences(c.ReferenceLimit)
+	}
+	return ec
+}
@@ -0,0 +1,66 @@
+package encs
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+var (
+	copiers      = make(map[reflect.Type]*encodable.Concurrent)
+	copiersMutex sync.Mutex
+)
+
+// Copy deep-copies the value pointed to by src into the value pointed to by dst.
+// The result is the same as encoding src with an Encoder and decoding into dst with a Decoder using the default Config,
+// without the cost of encoding to bytes; see encodable.Clone.
+// dst and src must be non-nil pointers to the same type.
+// It is thread safe.
+func Copy(dst, src interface{}) error {
+	if dst == nil || src == nil {
+		return encio.NewError(encio.ErrNilPointer, "cannot copy nil interface", 0)
+	}
+
+	dv, sv := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if dv.Type() != sv.Type() {
+		return encio.NewError(encio.ErrBadType, fmt.Sprintf("cannot copy %v into %v", sv.Type(), dv.Type()), 0)
+	}
+	if dv.Kind() != reflect.Ptr {
+		return encio.NewError(encio.ErrBadType, fmt.Sprintf("values must be passed by reference (pointer), got %v", dv.Type()), 0)
+	}
+	if dv.IsNil() || sv.IsNil() {
+		return encio.NewError(encio.ErrNilPointer, "cannot copy nil pointer", 0)
+	}
+
+	enc, err := getCopier(dv.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	return encodable.Clone(enc, unsafe.Pointer(dv.Pointer()), unsafe.Pointer(sv.Pointer()))
+}
+
+func getCopier(t reflect.Type) (*encodable.Concurrent, error) {
+	copiersMutex.Lock()
+	defer copiersMutex.Unlock()
+
+	if enc, ok := copiers[t]; ok {
+		return enc, nil
+	}
+
+	config := (*Config)(nil).copyAndFill().encodableConfig()
+	if _, err := encodable.New(t, config); err != nil {
+		return nil, err
+	}
+
+	enc := encodable.NewConcurrent(func() encodable.Encodable {
+		enc, _ := encodable.New(t, config) // t was checked above.
+		return enc
+	})
+	copiers[t] = enc
+	return enc, nil
+}
@@ -12,19 +12,54 @@ import (
 
 func NewDecoder(r io.Reader, config *Config) *Decoder {
 	config = config.copyAndFill()
-	return &Decoder{
-		r:        r,
-		resolver: config.Resolver,
-		source: encodable.NewSource(&encodable.Config{
-			Resolver: config.Resolver,
-		}, encodable.New),
+	ec := config.encodableConfig()
+	d := &Decoder{
+		r:          r,
+		resolver:   config.Resolver,
+		source:     encodable.NewSource(ec, encodable.New),
+		config:     config,
+		readHeader: config.Header,
+		references: ec.References,
 	}
+	if config.Fingerprint {
+		d.fingerprints = newTypeFingerprints(config)
+	}
+	if config.Delta {
+		d.previous = make(map[reflect.Type]reflect.Value)
+	}
+	return d
 }
 
 type Decoder struct {
 	r        io.Reader
 	resolver encodable.Resolver
 	source   *encodable.Source
+	config   *Config
+
+	// readHeader is true if the stream header is yet to be read.
+	readHeader bool
+
+	// fingerprints is nil unless Config.Fingerprint is set.
+	fingerprints *typeFingerprints
+
+	// references is nil unless Config.PersistReferences is set.
+	references *encodable.References
+
+	// previous is nil unless Config.Delta is set.
+	// It holds a copy of the last value of each type that was received.
+	previous map[reflect.Type]reflect.Value
+}
+
+// Reset empties the reference table kept with Config.PersistReferences, and forgets the values kept with Config.Delta.
+// It must be called at the same point in the stream as the Encoder's Reset.
+// It does nothing if neither PersistReferences nor Delta are set.
+func (d *Decoder) Reset() {
+	if d.references != nil {
+		d.references.Reset()
+	}
+	if d.previous != nil {
+		d.previous = make(map[reflect.Type]reflect.Value)
+	}
 }
 
 func (d *Decoder) Decode(v interface{}) error {
@@ -45,14 +80,76 @@ func (d *Decoder) Decode(v interface{}) error {
 		return encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not mutable", val.Type()), 0)
 	}
 
-	ty, err := d.resolver.Decode(val.Type(), d.r)
+	return d.decode(val.Type(), unsafe.Pointer(val.UnsafeAddr()))
+}
+
+// decode reads a type, checking it is t, and decodes the value into ptr.
+// ptr must be a valid pointer to a value of type t.
+func (d *Decoder) decode(t reflect.Type, ptr unsafe.Pointer) error {
+	if d.readHeader {
+		if err := readHeader(d.r, d.config); err != nil {
+			return err
+		}
+		d.readHeader = false
+	}
+
+	ty, err := d.resolver.Decode(t, d.r)
 	if err != nil {
 		return err
 	}
 
-	if ty != val.Type() {
-		return encio.NewError(encio.ErrBadType, fmt.Sprintf("cannot set %v to received type %v", val.Type(), ty), 0)
+	if ty != t {
+		return encio.NewError(encio.ErrBadType, fmt.Sprintf("cannot set %v to received type %v", t, ty), 1)
 	}
 
-	return d.source.GetEncodable(ty).Decode(unsafe.Pointer(val.UnsafeAddr()), d.r)
+	enc, err := d.source.GetEncodable(ty)
+	if err != nil {
+		return err
+	}
+
+	if d.fingerprints != nil {
+		var fp [8]byte
+		if err := encio.Read(fp[:], d.r); err != nil {
+			return err
+		}
+		want, err := d.fingerprints.get(ty)
+		if err != nil {
+			return err
+		}
+		if fp != want {
+			return encio.NewError(encio.ErrBadConfig, fmt.Sprintf(
+				"received %v with fingerprint %x, want %x; the encoder's config or version of the type differs from %v",
+				ty, fp, want, enc,
+			), 1)
+		}
+	}
+
+	if d.previous == nil {
+		return enc.Decode(ptr, d.r)
+	}
+	return d.decodeDelta(ty, enc, ptr)
+}
+
+// decodeDelta reads the changes from the last value of type t, or the value in full if it is the first,
+// keeping a copy of the result and copying it to ptr.
+func (d *Decoder) decodeDelta(t reflect.Type, enc encodable.Encodable, ptr unsafe.Pointer) error {
+	prev, ok := d.previous[t]
+	if !ok {
+		if err := enc.Decode(ptr, d.r); err != nil {
+			return err
+		}
+		prev = reflect.New(t)
+		if err := encodable.Clone(enc, unsafe.Pointer(prev.Pointer()), ptr); err != nil {
+			return err
+		}
+		d.previous[t] = prev
+		return nil
+	}
+
+	if err := encodable.DecodeDelta(enc, unsafe.Pointer(prev.Pointer()), d.r); err != nil {
+		// the kept value may be partly changed.
+		delete(d.previous, t)
+		return err
+	}
+	return encodable.Clone(enc, ptr, unsafe.Pointer(prev.Pointer()))
 }
@@ -0,0 +1,148 @@
+package encs_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs"
+	"github.com/stewi1014/encs/encodable"
+)
+
+type deltaPosition struct {
+	X, Y float64
+}
+
+type deltaSnapshot struct {
+	Tick     int
+	Name     string
+	Position deltaPosition
+	Path     []deltaPosition
+	Scores   map[string]int
+	Target   *deltaPosition
+	Flags    [4]bool
+}
+
+func TestDelta(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	config := &encs.Config{Resolver: resolver, Delta: true, Header: true}
+
+	buff := new(bytes.Buffer)
+	enc := encs.NewEncoder(buff, config)
+	dec := encs.NewDecoder(buff, config)
+
+	snapshot := deltaSnapshot{
+		Name:     "player",
+		Position: deltaPosition{1, 2},
+		Path:     []deltaPosition{{1, 2}, {3, 4}, {5, 6}},
+		Scores:   map[string]int{"a": 1, "b": 2},
+	}
+
+	var decoded deltaSnapshot
+	compare, err := encodable.New(reflect.TypeOf(snapshot), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() int {
+		start := buff.Len()
+		if err := enc.Encode(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+		n := buff.Len() - start
+		if err := dec.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		if diffs := encodable.Diff(compare, unsafe.Pointer(&snapshot), unsafe.Pointer(&decoded)); diffs != nil {
+			t.Fatalf("decoded value differs: %v", diffs)
+		}
+		return n
+	}
+
+	full := send()
+
+	snapshot.Tick++
+	if n := send(); n >= full/2 {
+		t.Errorf("changing one member took %v bytes; the full value took %v", n, full)
+	}
+
+	// the 8-byte type ID, and a byte saying nothing changed.
+	if n := send(); n != 9 {
+		t.Errorf("sending the same value took %v bytes, want 9", n)
+	}
+
+	snapshot.Tick++
+	snapshot.Position.Y = 3
+	snapshot.Path = append(snapshot.Path[:1:1], deltaPosition{7, 8})
+	delete(snapshot.Scores, "a")
+	snapshot.Scores["b"] = 3
+	snapshot.Scores["c"] = 4
+	snapshot.Target = &deltaPosition{9, 10}
+	snapshot.Flags[2] = true
+	send()
+
+	snapshot.Path = nil
+	snapshot.Target.X = 11
+	send()
+
+	// the decoded value doesn't share memory with the Decoder's copy.
+	decoded.Scores["d"] = 5
+	snapshot.Tick++
+	send()
+
+	// after Reset, values are sent in full.
+	enc.Reset()
+	dec.Reset()
+	plain := new(bytes.Buffer)
+	if err := encs.NewEncoder(plain, &encs.Config{Resolver: resolver}).Encode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := send(); n != plain.Len() {
+		t.Errorf("sending after Reset took %v bytes, want the %v bytes of the full value", n, plain.Len())
+	}
+}
+
+type deltaPointers struct {
+	P, Q, R *deltaPosition
+	S       []*deltaPosition
+}
+
+func TestDeltaAliasing(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	config := &encs.Config{Resolver: resolver, Delta: true}
+
+	buff := new(bytes.Buffer)
+	enc := encs.NewEncoder(buff, config)
+	dec := encs.NewDecoder(buff, config)
+
+	var value, decoded deltaPointers
+	compare, err := encodable.New(reflect.TypeOf(value), &encodable.Config{Resolver: resolver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() {
+		if err := enc.Encode(&value); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		if diffs := encodable.Diff(compare, unsafe.Pointer(&value), unsafe.Pointer(&decoded)); diffs != nil {
+			t.Fatalf("decoded value differs: %v", diffs)
+		}
+	}
+
+	x, y := &deltaPosition{X: 1}, &deltaPosition{X: 2}
+	value = deltaPointers{P: x, Q: x, R: y, S: []*deltaPosition{x, y}}
+	send()
+
+	// changing P mustn't change the value Q still shares with the old P.
+	value.P = &deltaPosition{X: 3}
+	send()
+
+	// the element dropped from S stays in its backing array, and is shared with R.
+	value.S = value.S[:1]
+	send()
+	value.S = append(value.S, &deltaPosition{X: 4})
+	send()
+}
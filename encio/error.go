@@ -38,6 +38,13 @@ var (
 	// ErrBadConfig is returned when the config cannot be used to encode the given encodable.
 	// i.e. Config.Resolver = nil when creating Interface Encodables.
 	ErrBadConfig = errors.New("bad config")
+
+	// ErrOverflow is returned when a decoded value cannot be represented by the type it is being decoded into.
+	// i.e. decoding an int that was encoded on a 64bit machine on a 32bit machine.
+	ErrOverflow = errors.New("overflow")
+
+	// ErrBadVersion is returned when the read data was written by an unsupported version of encs.
+	ErrBadVersion = errors.New("unsupported version")
 )
 
 // NewIOError returns an IOError wrapping err with the given message.
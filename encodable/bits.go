@@ -0,0 +1,132 @@
+package encodable
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+)
+
+// bitmap is a set of bits; bit i is bit i%8 of byte i/8.
+type bitmap []byte
+
+func newBitmap(n int) bitmap {
+	return make(bitmap, (n+7)/8)
+}
+
+func (b bitmap) set(i int) {
+	b[i/8] |= 1 << (i % 8)
+}
+
+func (b bitmap) get(i int) bool {
+	return b[i/8]&(1<<(i%8)) != 0
+}
+
+func (b bitmap) clear() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// put sets the n bits starting at bit i to the low n bits of v.
+func (b bitmap) put(i, n int, v uint64) {
+	for j := 0; j < n; j++ {
+		if v&(1<<j) != 0 {
+			b.set(i + j)
+		}
+	}
+}
+
+// take returns the n bits starting at bit i.
+func (b bitmap) take(i, n int) (v uint64) {
+	for j := 0; j < n; j++ {
+		if b.get(i + j) {
+			v |= 1 << j
+		}
+	}
+	return
+}
+
+// putBools sets bits in b for the true bools in the n bools at ptr.
+func (b bitmap) putBools(ptr unsafe.Pointer, n int) {
+	for i := 0; i < n; i++ {
+		if *(*bool)(unsafe.Pointer(uintptr(ptr) + uintptr(i))) {
+			b.set(i)
+		}
+	}
+}
+
+// takeBools sets the n bools at ptr to the first n bits of b.
+func (b bitmap) takeBools(ptr unsafe.Pointer, n int) {
+	for i := 0; i < n; i++ {
+		*(*bool)(unsafe.Pointer(uintptr(ptr) + uintptr(i))) = b.get(i)
+	}
+}
+
+// canPackBits returns true if values of type t can be packed into bits wide fields.
+func canPackBits(t reflect.Type, bits int) bool {
+	switch t.Kind() {
+	case reflect.Bool:
+		return bits == 1
+	case reflect.Int, reflect.Uint, reflect.Uintptr:
+		// these are 64 bits on the wire regardless of the platform, so are packed as though they are 64 bits.
+		return bits > 0 && bits <= 64
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return bits > 0 && bits <= t.Bits()
+	default:
+		return false
+	}
+}
+
+// packBits returns the value of the bool or integer at ptr as a bits wide field,
+// returning an encio.ErrOverflow error if it doesn't fit.
+// Signed integers are written in two's complement.
+func packBits(t reflect.Type, ptr unsafe.Pointer, bits int) (uint64, error) {
+	v := reflect.NewAt(t, ptr).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if bits < 64 && (i < -1<<(bits-1) || i >= 1<<(bits-1)) {
+			return 0, encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit field", i, bits), 1)
+		}
+		return uint64(i) & (1<<bits - 1), nil
+	default:
+		u := v.Uint()
+		if bits < 64 && u>>bits != 0 {
+			return 0, encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit field", u, bits), 1)
+		}
+		return u, nil
+	}
+}
+
+// unpackBits sets the bool or integer at ptr to the value of a bits wide field,
+// returning an encio.ErrOverflow error, and leaving it untouched, if the value doesn't fit;
+// as can happen for int, uint and uintptr on 32 bit platforms.
+func unpackBits(t reflect.Type, ptr unsafe.Pointer, bits int, u uint64) error {
+	v := reflect.NewAt(t, ptr).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// sign extend
+		shift := 64 - uint(bits)
+		i := int64(u<<shift) >> shift
+		if v.OverflowInt(i) {
+			return encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit %v", i, t.Bits(), t), 1)
+		}
+		v.SetInt(i)
+	default:
+		if v.OverflowUint(u) {
+			return encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit %v", u, t.Bits(), t), 1)
+		}
+		v.SetUint(u)
+	}
+	return nil
+}
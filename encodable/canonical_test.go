@@ -0,0 +1,87 @@
+package encodable_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encodable"
+)
+
+type canonicalStruct struct {
+	Names    map[string]int
+	Floats   map[float64]string
+	Pointers map[string]*int
+	Nested   map[int]map[string]bool
+	Anything map[string]interface{}
+}
+
+func newCanonicalStruct() *canonicalStruct {
+	c := &canonicalStruct{
+		Names:    make(map[string]int),
+		Floats:   make(map[float64]string),
+		Pointers: make(map[string]*int),
+		Nested:   make(map[int]map[string]bool),
+		Anything: make(map[string]interface{}),
+	}
+
+	shared := new(int)
+	for i := 0; i < 100; i++ {
+		c.Names[fmt.Sprint(i)] = i
+		c.Floats[float64(i)/3] = fmt.Sprint(i)
+		if i%2 == 0 {
+			c.Pointers[fmt.Sprint(i)] = shared
+		} else {
+			n := i
+			c.Pointers[fmt.Sprint(i)] = &n
+		}
+		c.Nested[i] = map[string]bool{fmt.Sprint(i): true, fmt.Sprint(i + 1): false}
+		c.Anything[fmt.Sprint(i)] = i
+	}
+
+	// NaN keys are never equal, and encode identically; they must be ordered by value.
+	c.Floats[math.NaN()] = "a"
+	c.Floats[math.NaN()] = "b"
+	c.Floats[math.NaN()] = "c"
+
+	return c
+}
+
+func TestCanonical(t *testing.T) {
+	config := &encodable.Config{
+		Resolver:  encodable.NewRegisterResolver(nil),
+		Canonical: true,
+	}
+
+	var want []byte
+	for i := 0; i < 20; i++ {
+		// new values and Encodables each time, so nothing is cached between encodes.
+		val := newCanonicalStruct()
+		enc := encodable.MustNew(reflect.TypeOf(val), config)
+
+		buff := new(bytes.Buffer)
+		if err := enc.Encode(unsafe.Pointer(&val), buff); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+
+		if want == nil {
+			want = buff.Bytes()
+
+			var decoded *canonicalStruct
+			if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if diffs := encodable.Diff(enc, unsafe.Pointer(&val), unsafe.Pointer(&decoded)); diffs != nil {
+				t.Fatalf("decoded value differs: %v", diffs)
+			}
+			continue
+		}
+
+		if !bytes.Equal(want, buff.Bytes()) {
+			t.Fatalf("encode %v gave different bytes", i)
+		}
+	}
+}
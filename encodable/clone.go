@@ -0,0 +1,329 @@
+package encodable
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+)
+
+// Clone deep-copies the value at src into dst, walking enc's tree of Encodables without encoding to bytes.
+// The result is the same as encoding src with enc and decoding into dst;
+// fields that are not encoded are left untouched, existing values in dst are reused where Decode would reuse them,
+// except for pointees in dst that have already been reused for a different pointer in src,
+// and pointer aliasing and cycles are reproduced as referencer would, along with slice and map aliasing if Config.Aliasing is set.
+// Types held in interfaces are passed through the Config's Resolver, so they must be resolvable just as they would for encoding.
+//
+// dst and src must be pointers to values of enc's type.
+func Clone(enc Encodable, dst, src unsafe.Pointer) error {
+	checkPtr(dst)
+	checkPtr(src)
+
+	c := new(cloner)
+	return c.clone(enc, dst, src)
+}
+
+// cloner walks Encodable trees, copying values.
+type cloner struct {
+	// references maps source pointers to their copies, mirroring referencer.
+	references map[referenceKey]unsafe.Pointer
+
+	// claimed holds the pointees in dst that have been reused for a source pointer.
+	// Other pointers in dst may share them, so they can't be reused again for a different source pointer.
+	claimed map[unsafe.Pointer]bool
+
+	// arrays maps the last elements of the backing arrays of aliased slices to their copies, mirroring referencer.
+	arrays map[unsafe.Pointer]clonedArray
+
+	buff bytes.Buffer
+}
+
+func (c *cloner) clone(enc Encodable, dst, src unsafe.Pointer) error {
+	switch e := enc.(type) {
+	case *referencer:
+		return c.clone(e.enc, dst, src)
+
+	case *Concurrent:
+		ce := e.get()
+		defer e.put(ce)
+		return c.clone(ce, dst, src)
+
+	case *Pointer:
+		return c.reference(e.elem, (*unsafe.Pointer)(dst), *(*unsafe.Pointer)(src), e.r != nil)
+
+	case *Interface:
+		return c.iface(e, dst, src)
+
+	case *Nil:
+		return e.Decode(dst, nil)
+
+	case *Struct:
+		for _, m := range e.members {
+			if e.merge && m.omitEmpty && m.isZero(src) {
+				// would be left out of the encoded struct, and kept in dst.
+				continue
+			}
+			err := c.clone(m.Encodable, unsafe.Pointer(uintptr(dst)+m.offset), unsafe.Pointer(uintptr(src)+m.offset))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *Array:
+		esize := e.elem.Type().Size()
+		for i := uintptr(0); i < e.len; i++ {
+			err := c.clone(e.elem, unsafe.Pointer(uintptr(dst)+i*esize), unsafe.Pointer(uintptr(src)+i*esize))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *Slice:
+		return c.slice(e, dst, src)
+
+	case *Map:
+		return c.mapping(e, dst, src)
+
+	case *String, *Bool, *Uint8, *Uint16, *Uint32, *Uint64, *Uint, *Uintptr,
+		*Int8, *Int16, *Int32, *Int64, *Int,
+		*Float32, *Float64, *Complex64, *Complex128:
+		// These are encoded bit-for-bit, so copying is the same as encoding and decoding.
+		t := enc.Type()
+		reflect.NewAt(t, dst).Elem().Set(reflect.NewAt(t, src).Elem())
+		return nil
+
+	default:
+		// We don't know how enc is structured, so go through its encoded form.
+		c.buff.Reset()
+		if err := enc.Encode(src, &c.buff); err != nil {
+			return err
+		}
+		return enc.Decode(dst, &c.buff)
+	}
+}
+
+// reference copies the value pointed to by src, pointing dst to the copy in the same manner as referencer.
+// If referenced is false, every pointer gets its own copy, as it does with Pointers without a referencer.
+func (c *cloner) reference(elem Encodable, dst *unsafe.Pointer, src unsafe.Pointer, referenced bool) error {
+	if src == nil {
+		*dst = nil
+		return nil
+	}
+
+	if !referenced {
+		if *dst == nil {
+			newAt(dst, elem.Type())
+		}
+		return c.clone(elem, *dst, src)
+	}
+
+	if c.references == nil {
+		c.references = make(map[referenceKey]unsafe.Pointer)
+		c.claimed = make(map[unsafe.Pointer]bool)
+	}
+
+	key := referenceKey{ptr: src, t: elem.Type()}
+	if copied, ok := c.references[key]; ok {
+		*dst = copied
+		return nil
+	}
+
+	if *dst == nil || c.claimed[*dst] {
+		newAt(dst, elem.Type())
+	}
+
+	c.references[key] = *dst // Must be before cloning the elem in case it references itself.
+	c.claimed[*dst] = true
+	return c.clone(elem, *dst, src)
+}
+
+func (c *cloner) iface(e *Interface, dst, src unsafe.Pointer) error {
+	si, di := reflect.NewAt(e.t, src).Elem(), reflect.NewAt(e.t, dst).Elem()
+	if si.IsNil() {
+		di.Set(reflect.New(e.t).Elem())
+		return nil
+	}
+
+	var expected reflect.Type
+	if !di.IsNil() {
+		expected = di.Elem().Type()
+	}
+
+	// resolve the type as the Decoder would see it.
+	c.buff.Reset()
+	if err := e.state.Resolver.Encode(si.Elem().Type(), &c.buff); err != nil {
+		return err
+	}
+	ty, err := e.state.Resolver.Decode(expected, &c.buff)
+	if err != nil {
+		return err
+	}
+	if ty != si.Elem().Type() {
+		return encio.NewError(encio.ErrBadType, fmt.Sprintf("resolver returned %v for %v", ty, si.Elem().Type()), 0)
+	}
+
+	enc, err := e.getEncodable(ty)
+	if err != nil {
+		return err
+	}
+
+	// interface contents aren't addressable; work on copies.
+	sv, dv := reflect.New(ty), reflect.New(ty)
+	sv.Elem().Set(si.Elem())
+	if expected == ty {
+		// re-use the existing value
+		dv.Elem().Set(di.Elem())
+	}
+
+	if err := c.clone(enc, unsafe.Pointer(dv.Pointer()), unsafe.Pointer(sv.Pointer())); err != nil {
+		return err
+	}
+
+	di.Set(dv.Elem())
+	return nil
+}
+
+// clonedArray is a backing array copied by cloner.
+type clonedArray struct {
+	src, dst unsafe.Pointer
+}
+
+func (c *cloner) slice(e *Slice, dst, src unsafe.Pointer) error {
+	if e.r != nil {
+		return c.aliasedSlice(e, dst, src)
+	}
+
+	ss, ds := reflect.NewAt(e.t, src).Elem(), reflect.NewAt(e.t, dst).Elem()
+	l := ss.Len()
+
+	start := 0
+	if e.merge {
+		start = ds.Len()
+	}
+
+	if l == 0 {
+		if !e.merge {
+			ds.SetLen(0)
+			ds.SetCap(0)
+		}
+		return nil
+	}
+
+	growSlice(ds, start+l, start)
+
+	for i := 0; i < l; i++ {
+		err := c.clone(e.elem, unsafe.Pointer(ds.Index(start+i).UnsafeAddr()), unsafe.Pointer(ss.Index(i).UnsafeAddr()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aliasedSlice copies a slice in the same manner as referencer; backing arrays are copied whole, and only once.
+func (c *cloner) aliasedSlice(e *Slice, dst, src unsafe.Pointer) error {
+	ss, ds := (*sliceHeader)(src), (*sliceHeader)(dst)
+	if ss.data == nil {
+		*ds = sliceHeader{}
+		return nil
+	}
+
+	size := e.t.Elem().Size()
+	var last unsafe.Pointer
+	if ss.cap > 0 && size > 0 {
+		if c.arrays == nil {
+			c.arrays = make(map[unsafe.Pointer]clonedArray)
+		}
+
+		last = unsafe.Pointer(uintptr(ss.data) + uintptr(ss.cap-1)*size)
+		if array, ok := c.arrays[last]; ok && uintptr(ss.data) >= uintptr(array.src) {
+			*ds = sliceHeader{
+				data: unsafe.Pointer(uintptr(array.dst) + (uintptr(ss.data) - uintptr(array.src))),
+				len:  ss.len,
+				cap:  ss.cap,
+			}
+			return nil
+		}
+	}
+
+	reflect.NewAt(e.t, dst).Elem().Set(reflect.MakeSlice(e.t, ss.len, ss.cap))
+	if last != nil {
+		c.arrays[last] = clonedArray{src: ss.data, dst: ds.data} // Must be before cloning elements in case they reference the array.
+	}
+
+	for i := 0; i < ss.cap; i++ {
+		err := c.clone(e.elem, unsafe.Pointer(uintptr(ds.data)+uintptr(i)*size), unsafe.Pointer(uintptr(ss.data)+uintptr(i)*size))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cloner) mapping(e *Map, dst, src unsafe.Pointer) error {
+	sm := reflect.NewAt(e.t, src).Elem()
+	if e.r != nil {
+		header := *(*unsafe.Pointer)(src)
+		if header == nil {
+			reflect.NewAt(e.t, dst).Elem().Set(reflect.Zero(e.t))
+			return nil
+		}
+
+		if c.references == nil {
+			c.references = make(map[referenceKey]unsafe.Pointer)
+		}
+		if copied, ok := c.references[referenceKey{ptr: header, t: e.t}]; ok {
+			*(*unsafe.Pointer)(dst) = copied
+			return nil
+		}
+	}
+
+	// like Decode, non-aliased maps in dst are reused.
+	dm := reflect.NewAt(e.t, dst).Elem()
+	if e.r != nil || dm.IsNil() || dm.Pointer() == sm.Pointer() {
+		dm = reflect.MakeMapWithSize(e.t, sm.Len())
+	} else if !e.merge {
+		for _, key := range dm.MapKeys() {
+			dm.SetMapIndex(key, reflect.Value{})
+		}
+		if dm.Len() != 0 {
+			// NaN keys can't be deleted.
+			dm = reflect.MakeMapWithSize(e.t, sm.Len())
+		}
+	}
+	if e.r != nil {
+		// Must be before cloning entries in case they reference the map.
+		reflect.NewAt(e.t, dst).Elem().Set(dm)
+		c.references[referenceKey{ptr: *(*unsafe.Pointer)(src), t: e.t}] = *(*unsafe.Pointer)(dst)
+	}
+
+	// map keys and values aren't addressable; work on copies.
+	sk, sv := reflect.New(e.t.Key()), reflect.New(e.t.Elem())
+	iter := sm.MapRange()
+	for iter.Next() {
+		sk.Elem().Set(iter.Key())
+		sv.Elem().Set(iter.Value())
+
+		dk, dv := reflect.New(e.t.Key()), reflect.New(e.t.Elem())
+		if err := c.clone(e.key, unsafe.Pointer(dk.Pointer()), unsafe.Pointer(sk.Pointer())); err != nil {
+			return err
+		}
+		if e.merge {
+			if existing := dm.MapIndex(dk.Elem()); existing.IsValid() {
+				dv.Elem().Set(existing)
+			}
+		}
+		if err := c.clone(e.val, unsafe.Pointer(dv.Pointer()), unsafe.Pointer(sv.Pointer())); err != nil {
+			return err
+		}
+
+		dm.SetMapIndex(dk.Elem(), dv.Elem())
+	}
+
+	reflect.NewAt(e.t, dst).Elem().Set(dm)
+	return nil
+}
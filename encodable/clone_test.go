@@ -0,0 +1,92 @@
+package encodable_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encodable"
+)
+
+func TestClone(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	resolver.Register([]int{})
+
+	shared := &equalStruct{Name: "shared"}
+	src := &equalStruct{
+		Name:     "John",
+		Likes:    []string{"Computers", "Music"},
+		Friends:  map[string]int{"Jane": 1, "Joe": 2},
+		Worst:    shared,
+		Anything: []int{1, 2, 3},
+		private:  5,
+	}
+	src.Best = src
+	shared.Best = shared
+
+	enc := encodable.MustNew(reflect.TypeOf(src), &encodable.Config{Resolver: resolver})
+
+	var dst *equalStruct
+	if err := encodable.Clone(enc, unsafe.Pointer(&dst), unsafe.Pointer(&src)); err != nil {
+		t.Fatalf("clone error: %v", err)
+	}
+
+	if !encodable.Equal(enc, unsafe.Pointer(&src), unsafe.Pointer(&dst)) {
+		t.Fatalf("clone is not equal; %v", encodable.Diff(enc, unsafe.Pointer(&src), unsafe.Pointer(&dst)))
+	}
+
+	if dst == src || dst.Worst == shared {
+		t.Errorf("pointers were copied instead of the values they point to")
+	}
+
+	if dst.Best != dst || dst.Worst.Best != dst.Worst {
+		t.Errorf("cyclic references were not preserved")
+	}
+
+	if dst.private != 0 {
+		t.Errorf("unexported field was copied")
+	}
+
+	src.Likes[0] = "Art"
+	src.Friends["Joe"] = 3
+	src.Anything.([]int)[0] = 4
+	if dst.Likes[0] != "Computers" || dst.Friends["Joe"] != 2 || dst.Anything.([]int)[0] != 1 {
+		t.Errorf("clone shares memory with the original; %v", dst)
+	}
+}
+
+func TestCloneUnregistered(t *testing.T) {
+	type unregistered struct{}
+	src := equalStruct{Anything: unregistered{}}
+	var dst equalStruct
+
+	resolver := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{Policy: encodable.PolicyStrict})
+	enc := encodable.MustNew(reflect.TypeOf(src), &encodable.Config{Resolver: resolver})
+	err := encodable.Clone(enc, unsafe.Pointer(&dst), unsafe.Pointer(&src))
+	if !errors.Is(err, encodable.ErrNotRegistered) {
+		t.Errorf("got error %v, want %v", err, encodable.ErrNotRegistered)
+	}
+}
+
+type clonePair struct {
+	P, Q *equalStruct
+}
+
+func TestCloneIntoShared(t *testing.T) {
+	enc := encodable.MustNew(reflect.TypeOf(clonePair{}), &encodable.Config{Resolver: encodable.NewRegisterResolver(nil)})
+
+	src := clonePair{P: &equalStruct{Name: "P"}, Q: &equalStruct{Name: "Q"}}
+	shared := &equalStruct{Name: "shared"}
+	dst := clonePair{P: shared, Q: shared}
+	if err := encodable.Clone(enc, unsafe.Pointer(&dst), unsafe.Pointer(&src)); err != nil {
+		t.Fatal(err)
+	}
+
+	if diffs := encodable.Diff(enc, unsafe.Pointer(&src), unsafe.Pointer(&dst)); diffs != nil {
+		t.Errorf("clone differs: %v", diffs)
+	}
+	if dst.P != shared {
+		t.Errorf("P's existing value wasn't reused")
+	}
+}
@@ -1,10 +1,13 @@
 package encodable
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 	"unsafe"
@@ -12,27 +15,41 @@ import (
 	"github.com/stewi1014/encs/encio"
 )
 
-// NewPointer returns a new Pointer Encodable.
-func NewPointer(t reflect.Type, config *Config) Encodable {
-	return newPointer(t, config.genState())
+// checkNew returns an encio.ErrBadType error if t isn't of the given kind, or can't be encoded with config.
+// It is used by the New* functions of compound Encodables, which return errors as New does;
+// the new* functions they call take t to have been checked, and don't check it again.
+func checkNew(t reflect.Type, kind reflect.Kind, config *Config) error {
+	if t.Kind() != kind {
+		return encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not a %v", t, kind), 1)
+	}
+	return checkType(t, config)
 }
 
-func newPointer(t reflect.Type, state *state) (enc Encodable) { // TODO; improve performance in some cases by not using referencer when we can garuntee no self-references *that does not mean only recursive types*.
-	if t.Kind() != reflect.Ptr {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not a pointer", t), 0))
+// NewPointer returns a new Pointer Encodable.
+func NewPointer(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Ptr, config); err != nil {
+		return nil, err
 	}
+	state := config.genState(t)
+	return state.root(newPointer(t, state)), nil
+}
 
+func newPointer(t reflect.Type, state *state) *Pointer {
 	e := &Pointer{
 		ty:   t,
 		buff: make([]byte, 1),
 	}
 
-	e.r, enc = state.referencer(e)
-	e.elem = e.r.newEncodable(t.Elem(), state)
-	return
+	if !state.tree {
+		e.r = state.r
+	}
+	e.elem = state.newEncodable(t.Elem())
+	return e
 }
 
 // Pointer encodes pointers to concrete types.
+// If the type can't hold references, or Config.TreeShaped is set, it doesn't use a referencer,
+// and writes the same nil or encoded byte that a referencer would, but never a reference.
 type Pointer struct {
 	ty   reflect.Type
 	r    *referencer
@@ -40,23 +57,25 @@ type Pointer struct {
 	buff []byte
 }
 
-const (
-	encodedPtr = iota
-	nilPtr
-)
-
 // String implements Encodable
 func (e *Pointer) String() string {
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Pointer) stringVisiting(visiting map[*Concurrent]bool) string {
 	if e.r != nil {
 		// Not particularly relevant to callers except that when using strings to equality check,
 		// the configuration of the resolver is important; it effects the encoded format.
-		return fmt.Sprintf("Pointer(resolver at %v){%v}", e.r.Type().String(), e.elem.String())
+		return fmt.Sprintf("Pointer(resolver at %v){%v}", e.r.Type().String(), stringOf(e.elem, visiting))
 	}
-	return fmt.Sprintf("Pointer{%v}", e.elem.String())
+	return fmt.Sprintf("Pointer{%v}", stringOf(e.elem, visiting))
 }
 
 // Size implements Sized
 func (e *Pointer) Size() int {
+	if e.r == nil {
+		return e.elem.Size() + 1
+	}
 	return e.elem.Size() + 5
 }
 
@@ -69,44 +88,110 @@ func (e *Pointer) Type() reflect.Type {
 func (e *Pointer) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
 
-	return e.r.encodeReference(*(*unsafe.Pointer)(ptr), e.elem, w)
+	if e.r != nil {
+		return e.r.encodeReference(*(*unsafe.Pointer)(ptr), e.ty.Elem(), e.elem, w)
+	}
+
+	elem := *(*unsafe.Pointer)(ptr)
+	if elem == nil {
+		e.buff[0] = refNil
+		return encio.Write(e.buff, w)
+	}
+
+	e.buff[0] = refEncoded
+	if err := encio.Write(e.buff, w); err != nil {
+		return err
+	}
+	return e.elem.Encode(elem, w)
 }
 
 // Decode implements Encodable
 func (e *Pointer) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
 
-	return e.r.decodeReference((*unsafe.Pointer)(ptr), e.elem, r)
+	if e.r != nil {
+		return e.r.decodeReference((*unsafe.Pointer)(ptr), e.ty.Elem(), e.elem, r)
+	}
+
+	if err := encio.Read(e.buff, r); err != nil {
+		return err
+	}
+
+	elem := (*unsafe.Pointer)(ptr)
+	switch e.buff[0] {
+	case refNil:
+		*elem = nil
+		return nil
+	case refEncoded:
+		if *elem == nil {
+			newAt(elem, e.elem.Type())
+		}
+		return e.elem.Decode(*elem, r)
+	default:
+		return encio.IOError{
+			Err:     encio.ErrMalformed,
+			Message: "pointer byte is not nil or encoded",
+		}
+	}
 }
 
 // NewMap returns a new map Encodable
-func NewMap(t reflect.Type, config *Config) *Map {
-	return newMap(t, config.genState())
+func NewMap(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Map, config); err != nil {
+		return nil, err
+	}
+	state := config.genState(t)
+	return state.root(newMap(t, state)), nil
 }
 
 func newMap(t reflect.Type, state *state) *Map {
-	if t.Kind() != reflect.Map {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not a map", t), 0))
+	e := &Map{
+		key:   newEncodable(t.Key(), state),
+		val:   newEncodable(t.Elem(), state),
+		t:     t,
+		state: state,
 	}
-
-	return &Map{
-		key:  newEncodable(t.Key(), state),
-		val:  newEncodable(t.Elem(), state),
-		buff: make([]byte, 4),
-		t:    t,
+	if state.Aliasing && !state.tree {
+		e.r = state.r
+	} else {
+		e.merge = state.Merge
 	}
+	return e
 }
 
-// Map is an Encodable for maps
+// Map is an Encodable for maps.
+// If Config.Canonical is set, entries are written in order of their encoded keys.
+// If Config.Aliasing is set, maps are written once, with references to the first write in subsequent writes.
 type Map struct {
 	key, val Encodable
-	buff     []byte
+	len      encio.Uvarint
 	t        reflect.Type
+	state    *state
+	r        *referencer
+	merge    bool
+
+	// used for sorting entries in canonical mode.
+	entries     mapEntries
+	entryBuffer bytes.Buffer
 }
 
 // String implements Encodable
 func (e *Map) String() string {
-	return fmt.Sprintf("Map[%v]{%v}", e.key, e.val)
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Map) stringVisiting(visiting map[*Concurrent]bool) string {
+	var options []string
+	if e.state.Canonical {
+		options = append(options, "canonical")
+	}
+	if e.r != nil {
+		options = append(options, "aliased")
+	}
+	if len(options) > 0 {
+		return fmt.Sprintf("Map(%v)[%v]{%v}", strings.Join(options, ", "), stringOf(e.key, visiting), stringOf(e.val, visiting))
+	}
+	return fmt.Sprintf("Map[%v]{%v}", stringOf(e.key, visiting), stringOf(e.val, visiting))
 }
 
 // Size implements Encodable
@@ -122,25 +207,35 @@ func (e *Map) Type() reflect.Type {
 // Encode implements Encodable
 func (e *Map) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
-	v := reflect.NewAt(e.t, ptr).Elem()
 
-	l := uint32(v.Len())
-	e.buff[0] = uint8(l)
-	e.buff[1] = uint8(l >> 8)
-	e.buff[2] = uint8(l >> 16)
-	e.buff[3] = uint8(l >> 24)
-	if err := encio.Write(e.buff, w); err != nil {
+	if e.r != nil {
+		return e.r.encodeMap(ptr, e, w)
+	}
+	return e.encodeEntries(reflect.NewAt(e.t, ptr).Elem(), w)
+}
+
+// encodeEntries writes the length and entries of v.
+func (e *Map) encodeEntries(v reflect.Value, w io.Writer) error {
+	if err := e.len.Encode(w, uint32(v.Len())); err != nil {
 		return err
 	}
 
+	if e.state.Canonical {
+		return e.encodeCanonical(v, w)
+	}
+
+	// map keys and values aren't addressable; encode copies.
+	key, val := reflect.New(e.t.Key()), reflect.New(e.t.Elem())
 	iter := v.MapRange()
 	for iter.Next() {
-		err := e.key.Encode(unsafe.Pointer(iter.Key().UnsafeAddr()), w)
+		key.Elem().Set(iter.Key())
+		err := e.key.Encode(unsafe.Pointer(key.Pointer()), w)
 		if err != nil {
 			return err
 		}
 
-		err = e.val.Encode(unsafe.Pointer(iter.Value().UnsafeAddr()), w)
+		val.Elem().Set(iter.Value())
+		err = e.val.Encode(unsafe.Pointer(val.Pointer()), w)
 		if err != nil {
 			return err
 		}
@@ -149,22 +244,137 @@ func (e *Map) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	return nil
 }
 
-// Decode implements Encodable
+// encodeCanonical writes the entries of v ordered by their encoded key, and then encoded value for keys that encode identically.
+// Entries are encoded once for sorting, and again when written so that references are resolved in the written order.
+func (e *Map) encodeCanonical(v reflect.Value, w io.Writer) error {
+	e.entries = e.entries[:0]
+	e.entryBuffer.Reset()
+
+	var mark int
+	if e.state.r != nil {
+		mark = e.state.r.mark()
+	}
+
+	iter := v.MapRange()
+	for iter.Next() {
+		entry := mapEntry{
+			key: reflect.New(e.t.Key()),
+			val: reflect.New(e.t.Elem()),
+		}
+		entry.key.Elem().Set(iter.Key())
+		entry.val.Elem().Set(iter.Value())
+
+		start := e.entryBuffer.Len()
+		if err := e.key.Encode(unsafe.Pointer(entry.key.Pointer()), &e.entryBuffer); err != nil {
+			return err
+		}
+		entry.keyEnd = e.entryBuffer.Len() - start
+		if err := e.val.Encode(unsafe.Pointer(entry.val.Pointer()), &e.entryBuffer); err != nil {
+			return err
+		}
+		entry.end = e.entryBuffer.Len()
+
+		if e.state.r != nil {
+			e.state.r.truncate(mark)
+		}
+
+		e.entries = append(e.entries, entry)
+	}
+
+	// the buffer can move while growing, so entries are only sliced from it once it's complete.
+	buff, start := e.entryBuffer.Bytes(), 0
+	for i := range e.entries {
+		e.entries[i].encoded = buff[start:e.entries[i].end]
+		start = e.entries[i].end
+	}
+
+	sort.Sort(e.entries)
+
+	for _, entry := range e.entries {
+		if err := e.key.Encode(unsafe.Pointer(entry.key.Pointer()), w); err != nil {
+			return err
+		}
+		if err := e.val.Encode(unsafe.Pointer(entry.val.Pointer()), w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type mapEntry struct {
+	key, val reflect.Value
+	encoded  []byte
+	keyEnd   int
+	end      int
+}
+
+type mapEntries []mapEntry
+
+func (a mapEntries) Len() int      { return len(a) }
+func (a mapEntries) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a mapEntries) Less(i, j int) bool {
+	if c := bytes.Compare(a[i].encoded[:a[i].keyEnd], a[j].encoded[:a[j].keyEnd]); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a[i].encoded[a[i].keyEnd:], a[j].encoded[a[j].keyEnd:]) < 0
+}
+
+// Decode implements Encodable.
+// Decoding into a non-nil map replaces its entries, reusing the map unless it holds NaN keys, which can't be deleted,
+// or with Config.Merge, adds to them.
 func (e *Map) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
-	if err := encio.Read(e.buff, r); err != nil {
+
+	if e.r != nil {
+		return e.r.decodeMap(ptr, e, r)
+	}
+
+	l, err := e.decodeLen(r)
+	if err != nil {
 		return err
 	}
 
-	l := uint32(e.buff[0])
-	l |= uint32(e.buff[1]) << 8
-	l |= uint32(e.buff[2]) << 16
-	l |= uint32(e.buff[3]) << 24
+	m := reflect.NewAt(e.t, ptr).Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMapWithSize(e.t, l))
+	} else if !e.merge {
+		for _, key := range m.MapKeys() {
+			m.SetMapIndex(key, reflect.Value{})
+		}
+		if m.Len() != 0 {
+			// NaN keys can't be deleted.
+			m.Set(reflect.MakeMapWithSize(e.t, l))
+		}
+	}
 
-	v := reflect.NewAt(e.t, ptr)
-	v.Elem().Set(reflect.New(e.t).Elem())
+	return e.decodeEntries(m, l, r)
+}
+
+// decodeLen reads the number of entries in a map,
+// returning an encio.ErrMalformed error if the entries would be too big.
+func (e *Map) decodeLen(r io.Reader) (int, error) {
+	l, err := e.len.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// zero-sized entries still take time to decode.
+	// l is checked before it is converted to int, which can't hold it on 32 bit hosts.
+	size := uint64(e.t.Key().Size() + e.t.Elem().Size())
+	if size == 0 {
+		size = 1
+	}
+	if uint64(l) > uint64(encio.TooBig)/size {
+		return 0, encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("map with %v entries of %v bytes is too big", l, size), 0)
+	}
+	return int(l), nil
+}
 
-	for i := uint32(0); i < l; i++ {
+// decodeEntries reads l entries into m.
+// With Config.Merge, values for keys already in m are decoded into a copy of the existing value.
+func (e *Map) decodeEntries(m reflect.Value, l int, r io.Reader) error {
+	for i := 0; i < l; i++ {
 		nKey := reflect.New(e.key.Type())
 		err := e.key.Decode(unsafe.Pointer(nKey.Pointer()), r)
 		if err != nil {
@@ -172,30 +382,32 @@ func (e *Map) Decode(ptr unsafe.Pointer, r io.Reader) error {
 		}
 
 		nVal := reflect.New(e.val.Type())
+		if e.merge {
+			if existing := m.MapIndex(nKey.Elem()); existing.IsValid() {
+				nVal.Elem().Set(existing)
+			}
+		}
 		err = e.val.Decode(unsafe.Pointer(nVal.Pointer()), r)
 		if err != nil {
 			return err
 		}
 
-		v.SetMapIndex(nKey.Elem(), nVal.Elem())
+		m.SetMapIndex(nKey.Elem(), nVal.Elem())
 	}
 
 	return nil
 }
 
 // NewInterface returns a new interface Encodable
-func NewInterface(t reflect.Type, config *Config) Encodable { // TODO; improve performance in some cases by not using a referencer in cases where we can garuntee no self-references.
-	return newInterface(t, config.genState())
-}
-
-func newInterface(t reflect.Type, state *state) (enc Encodable) {
-	if t.Kind() != reflect.Interface {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not an interface", t), 0))
-	}
-	if state.Resolver == nil {
-		panic(encio.NewError(encio.ErrBadConfig, "interface encodables need a resolver to function (config.Resolver is nil)", 0))
+func NewInterface(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Interface, config); err != nil {
+		return nil, err
 	}
+	state := config.genState(t)
+	return state.root(newInterface(t, state)), nil
+}
 
+func newInterface(t reflect.Type, state *state) *Interface {
 	i := &Interface{
 		t:        t,
 		state:    state,
@@ -203,11 +415,11 @@ func newInterface(t reflect.Type, state *state) (enc Encodable) {
 		buff:     make([]byte, 1),
 	}
 
-	_, enc = state.referencer(i)
-	return enc
+	return i
 }
 
-// Interface is an Encodable for interfaces
+// Interface is an Encodable for interfaces.
+// The value is written directly after its type. Pointers inside it are still referenced as usual.
 type Interface struct {
 	t        reflect.Type
 	state    *state
@@ -245,24 +457,28 @@ func (e *Interface) Encode(ptr unsafe.Pointer, w io.Writer) error {
 		return encio.Write(e.buff, w)
 	}
 
-	e.buff[0] = ifNonNil
-	err := encio.Write(e.buff, w)
+	elemType := i.Elem().Type()
+	enc, err := e.getEncodable(elemType)
 	if err != nil {
 		return err
 	}
 
-	elemType := i.Elem().Type()
+	e.buff[0] = ifNonNil
+	err = encio.Write(e.buff, w)
+	if err != nil {
+		return err
+	}
 
 	err = e.state.Resolver.Encode(elemType, w)
 	if err != nil {
 		return err
 	}
 
-	elemEnc := e.getEncodable(elemType)
-	if e.state.r != nil {
-		return e.state.r.encodeReference(unsafe.Pointer(i.Pointer()), elemEnc, w)
-	}
-	return elemEnc.Encode(unsafe.Pointer(i.Pointer()), w)
+	// interface contents aren't addressable; encode a copy.
+	// the copy is new every time, so can't be referenced, and isn't given an index.
+	elem := reflect.New(elemType)
+	elem.Elem().Set(i.Elem())
+	return enc.Encode(unsafe.Pointer(elem.Pointer()), w)
 }
 
 // Decode implements Encodable
@@ -275,9 +491,17 @@ func (e *Interface) Decode(ptr unsafe.Pointer, r io.Reader) error {
 
 	i := reflect.NewAt(e.t, ptr).Elem()
 
-	if e.buff[0] == ifNil {
+	switch e.buff[0] {
+	case ifNil:
 		i.Set(reflect.New(e.t).Elem())
 		return nil
+	case ifNonNil:
+		break
+	default:
+		return encio.IOError{
+			Err:     encio.ErrMalformed,
+			Message: "interface byte is not nil or non-nil",
+		}
 	}
 
 	var elemt reflect.Type
@@ -290,76 +514,93 @@ func (e *Interface) Decode(ptr unsafe.Pointer, r io.Reader) error {
 		return err
 	}
 
-	// decode, pointing eptr to the decoded value.
+	enc, err := e.getEncodable(ty)
+	if err != nil {
+		return err
+	}
+
+	// interface contents aren't addressable; decode into a copy, re-using the existing value if possible.
 	var eptr unsafe.Pointer
-	// re-use existing pointer if possible
 	if elemt == ty {
-		eptr = unsafe.Pointer(i.Pointer())
+		existing := reflect.New(ty)
+		existing.Elem().Set(i.Elem())
+		eptr = unsafe.Pointer(existing.Pointer())
 	}
 
-	enc := e.getEncodable(ty)
-	if e.state.r != nil {
-		// decode into eptr with referencer
-		if err := e.state.r.decodeReference(&eptr, enc, r); err != nil {
-			return err
-		}
-	}
-	// do our own decoding
-	var new reflect.Value
 	if eptr == nil {
-		// we couldn't re-use our old value
-		new = reflect.New(enc.Type())
-		eptr = unsafe.Pointer(new.Pointer())
-		if err := enc.Decode(eptr, r); err != nil {
-			return err
-		}
-	} else {
-		// we could re-use our old value
-		new = reflect.NewAt(enc.Type(), eptr)
-		if err := enc.Decode(eptr, r); err != nil {
-			return err
-		}
+		newAt(&eptr, ty)
+	}
+	if err := enc.Decode(eptr, r); err != nil {
+		return err
 	}
-	i.Set(new.Elem())
+
+	i.Set(reflect.NewAt(ty, eptr).Elem())
 	return nil
 }
 
-func (e *Interface) getEncodable(t reflect.Type) Encodable {
+// getEncodable returns the Encodable for the type t held in the interface,
+// returning an error if it can't be encoded.
+func (e *Interface) getEncodable(t reflect.Type) (Encodable, error) {
 	if enc, ok := e.encoders[t]; ok {
-		return enc
+		return enc, nil
 	}
 
+	if err := checkType(t, &e.state.Config); err != nil {
+		return nil, err
+	}
 	enc := newEncodable(t, e.state)
 	e.encoders[t] = enc
-	return enc
+	return enc, nil
 }
 
 // NewSlice returns a new slice Encodable
-func NewSlice(t reflect.Type, config *Config) *Slice {
-	return newSlice(t, config.genState())
+func NewSlice(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Slice, config); err != nil {
+		return nil, err
+	}
+	state := config.genState(t)
+	return state.root(newSlice(t, state)), nil
 }
 
 func newSlice(t reflect.Type, state *state) *Slice {
-	if t.Kind() != reflect.Slice {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not a slice", t), 0))
-	}
-
-	return &Slice{
+	e := &Slice{
 		t:    t,
 		elem: newEncodable(t.Elem(), state),
 	}
+	if state.Aliasing && !state.tree {
+		e.r = state.r
+	} else {
+		e.packed = state.PackBits && t.Elem().Kind() == reflect.Bool
+		e.merge = state.Merge
+	}
+	return e
 }
 
-// Slice is an Encodable for slices
+// Slice is an Encodable for slices.
+// If Config.Aliasing is set, backing arrays are written once, with references to the first write for slices of them.
+// If Config.PackBits is set, bool slices are written as bitmaps.
 type Slice struct {
-	t    reflect.Type
-	elem Encodable
-	len  encio.Uvarint
+	t      reflect.Type
+	elem   Encodable
+	len    encio.Uvarint
+	r      *referencer
+	packed bool
+	merge  bool
 }
 
 // String implements Encodable
 func (e *Slice) String() string {
-	return fmt.Sprintf("[]%v", e.elem)
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Slice) stringVisiting(visiting map[*Concurrent]bool) string {
+	if e.r != nil {
+		return fmt.Sprintf("(aliased)[]%v", stringOf(e.elem, visiting))
+	}
+	if e.packed {
+		return fmt.Sprintf("(packed)[]%v", stringOf(e.elem, visiting))
+	}
+	return fmt.Sprintf("[]%v", stringOf(e.elem, visiting))
 }
 
 // Size implemenets Encodable
@@ -378,6 +619,10 @@ func (e *Slice) Type() reflect.Type {
 func (e *Slice) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
 
+	if e.r != nil {
+		return e.r.encodeSlice(ptr, e.t, e.elem, w)
+	}
+
 	slice := reflect.NewAt(e.t, ptr).Elem()
 	if slice.IsNil() {
 		return e.len.Encode(w, 0)
@@ -388,6 +633,12 @@ func (e *Slice) Encode(ptr unsafe.Pointer, w io.Writer) error {
 		return err
 	}
 
+	if e.packed {
+		bits := newBitmap(l)
+		bits.putBools((*sliceHeader)(ptr).data, l)
+		return encio.Write(bits, w)
+	}
+
 	for i := 0; i < l; i++ {
 		err := e.elem.Encode(unsafe.Pointer(slice.Index(i).UnsafeAddr()), w)
 		if err != nil {
@@ -403,6 +654,10 @@ func (e *Slice) Encode(ptr unsafe.Pointer, w io.Writer) error {
 func (e *Slice) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
 
+	if e.r != nil {
+		return e.r.decodeSlice(ptr, e.t, e.elem, r)
+	}
+
 	l32, err := e.len.Decode(r)
 	l := int(l32)
 	if err != nil {
@@ -418,20 +673,32 @@ func (e *Slice) Decode(ptr unsafe.Pointer, r io.Reader) error {
 
 	slice := reflect.NewAt(e.t, ptr).Elem()
 
+	// with Config.Merge, decoded elements are appended.
+	start := 0
+	if e.merge {
+		start = slice.Len()
+	}
+
 	if l == 0 {
-		slice.SetLen(0)
-		slice.SetCap(0)
+		if !e.merge {
+			slice.SetLen(0)
+			slice.SetCap(0)
+		}
 		return nil
 	}
 
-	if slice.Cap() < l {
-		// Not enough space, allocate
-		slice.Set(reflect.MakeSlice(e.t, l, l))
-	} else {
-		slice.SetLen(l)
+	growSlice(slice, start+l, start)
+
+	if e.packed {
+		bits := newBitmap(l)
+		if err := encio.Read(bits, r); err != nil {
+			return err
+		}
+		bits.takeBools(unsafe.Pointer(slice.Index(start).UnsafeAddr()), l)
+		return nil
 	}
 
-	for i := 0; i < l; i++ {
+	for i := start; i < start+l; i++ {
 		eptr := unsafe.Pointer(slice.Index(i).UnsafeAddr())
 		err := e.elem.Decode(eptr, r)
 		if err != nil {
@@ -442,37 +709,71 @@ func (e *Slice) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	return nil
 }
 
+// growSlice sets the length of slice to n, allocating a new backing array if its capacity is less than n.
+// The first keep elements are copied to the new backing array.
+func growSlice(slice reflect.Value, n, keep int) {
+	if slice.Cap() >= n {
+		slice.SetLen(n)
+		return
+	}
+
+	grown := reflect.MakeSlice(slice.Type(), n, n)
+	if keep > 0 {
+		reflect.Copy(grown, slice.Slice(0, keep))
+	}
+	slice.Set(grown)
+}
+
 // NewArray returns a new array Encodable
-func NewArray(t reflect.Type, config *Config) *Array {
+func NewArray(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Array, config); err != nil {
+		return nil, err
+	}
 	if config != nil {
 		config = config.copy()
 	}
-	return newArray(t, config.genState())
+	state := config.genState(t)
+	return state.root(newArray(t, state)), nil
 }
 
 func newArray(t reflect.Type, state *state) *Array {
-	if t.Kind() != reflect.Array {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not an Array", t), 0))
-	}
-	return &Array{
+	e := &Array{
 		elem: newEncodable(t.Elem(), state),
 		len:  uintptr(t.Len()),
 	}
+	if state.PackBits && t.Elem().Kind() == reflect.Bool {
+		e.packed = newBitmap(t.Len())
+	}
+	return e
 }
 
-// Array is an Encodable for arrays
+// Array is an Encodable for arrays.
+// If Config.PackBits is set, bool arrays are written as bitmaps.
 type Array struct {
 	elem Encodable
 	len  uintptr
+
+	// packed is non-nil if the array is written as a bitmap, holding it.
+	packed bitmap
 }
 
 // String implements Encodable
 func (e *Array) String() string {
-	return fmt.Sprintf("Array[%v]{%v}", e.len, e.elem)
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Array) stringVisiting(visiting map[*Concurrent]bool) string {
+	if e.packed != nil {
+		return fmt.Sprintf("Array[%v](packed){%v}", e.len, stringOf(e.elem, visiting))
+	}
+	return fmt.Sprintf("Array[%v]{%v}", e.len, stringOf(e.elem, visiting))
 }
 
 // Size implements Encodable
 func (e *Array) Size() int {
+	if e.packed != nil {
+		return len(e.packed)
+	}
 	s := e.elem.Size()
 	if s < 0 {
 		return -1 << 31
@@ -488,6 +789,11 @@ func (e *Array) Type() reflect.Type {
 // Encode implements Encodable
 func (e *Array) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
+	if e.packed != nil {
+		e.packed.clear()
+		e.packed.putBools(ptr, int(e.len))
+		return encio.Write(e.packed, w)
+	}
 	esize := e.elem.Type().Size()
 	for i := uintptr(0); i < e.len; i++ {
 		eptr := unsafe.Pointer(uintptr(ptr) + (i * esize))
@@ -502,6 +808,13 @@ func (e *Array) Encode(ptr unsafe.Pointer, w io.Writer) error {
 // Decode implments Encodable
 func (e *Array) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
+	if e.packed != nil {
+		if err := encio.Read(e.packed, r); err != nil {
+			return err
+		}
+		e.packed.takeBools(ptr, int(e.len))
+		return nil
+	}
 	esize := e.elem.Type().Size()
 	for i := uintptr(0); i < e.len; i++ {
 		eptr := unsafe.Pointer(uintptr(ptr) + (i * esize))
@@ -519,45 +832,100 @@ func (a structMembers) Len() int           { return len(a) }
 func (a structMembers) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a structMembers) Less(i, j int) bool { return a[i].Name < a[j].Name }
 
-// NewStruct returns a new struct Encodable
-func NewStruct(t reflect.Type, config *Config) *Struct {
-	if config != nil {
-		config = config.copy()
-	}
-	return newStruct(t, config.genState())
-}
-
-func newStruct(t reflect.Type, state *state) *Struct {
-	if t.Kind() != reflect.Struct {
-		panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("%v is not a struct", t), 0))
-	}
-
-	s := &Struct{
-		ty: t,
-	}
+// structFields returns the fields of the struct type t that are encoded with config, in the order they are encoded.
+func structFields(t reflect.Type, config *Config) structMembers {
 	n := t.NumField()
 	sms := make(structMembers, 0, n)
 	for i := 0; i < n; i++ {
 		f := t.Field(i)
-		if c, _ := utf8.DecodeRune([]byte(f.Name)); unicode.IsUpper(c) || state.IncludeUnexported {
-			sms = append(sms, f)
+		if c, _ := utf8.DecodeRune([]byte(f.Name)); !unicode.IsUpper(c) && !config.IncludeUnexported {
+			continue
+		}
+		if config.StructTag != "" {
+			if _, ok := f.Tag.Lookup(config.StructTag); !ok {
+				continue
+			}
 		}
+		if config.policy(f.Type.Kind()) == PolicySkip {
+			continue
+		}
+		sms = append(sms, f)
 	}
 
-	//TODO implement Config.StructTag
-
 	// struct members are sorted alphabetically. Since there is no coordination of member data,
 	// decoders must decode in the same order the encoders wrote.
 	// Alphabetically is a pretty platform-independant way of sorting the fields.
 	sort.Sort(sms)
+	return sms
+}
+
+// fieldTag holds the options given in a struct field's encs tag, i.e. `encs:"omitempty,bits=3"`.
+// Options are separated by commas, and unknown options are ignored.
+type fieldTag struct {
+	// omitEmpty leaves the field out of the encoded struct when it is zero, as Config.OmitZero does for every field.
+	omitEmpty bool
+
+	// bits packs a bool or integer field into a field of the given number of bits, if greater than zero.
+	// It is -1 if the bits option isn't a number.
+	bits int
+}
+
+func parseTag(f reflect.StructField) (tag fieldTag) {
+	for _, opt := range strings.Split(f.Tag.Get("encs"), ",") {
+		switch {
+		case opt == "omitempty":
+			tag.omitEmpty = true
+		case strings.HasPrefix(opt, "bits="):
+			bits, err := strconv.Atoi(strings.TrimPrefix(opt, "bits="))
+			if err != nil || bits <= 0 {
+				bits = -1
+			}
+			tag.bits = bits
+		}
+	}
+	return
+}
+
+// NewStruct returns a new struct Encodable
+func NewStruct(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkNew(t, reflect.Struct, config); err != nil {
+		return nil, err
+	}
+	if config != nil {
+		config = config.copy()
+	}
+	state := config.genState(t)
+	return state.root(newStruct(t, state)), nil
+}
+
+func newStruct(t reflect.Type, state *state) *Struct {
+	s := &Struct{
+		ty:    t,
+		merge: state.Merge,
+	}
+	sms := structFields(t, &state.Config)
 
 	s.members = make([]structMember, len(sms))
 	for i := range sms {
+		tag := parseTag(sms[i])
+		if tag.bits == 0 && state.PackBits && sms[i].Type.Kind() == reflect.Bool {
+			tag.bits = 1
+		}
+
 		s.members[i] = structMember{
 			Encodable: newEncodable(sms[i].Type, state),
 			offset:    sms[i].Offset,
+			name:      sms[i].Name,
+			omitEmpty: (state.OmitZero || tag.omitEmpty) && tag.bits == 0,
+			bits:      tag.bits,
 		}
+		if s.members[i].omitEmpty {
+			s.optional++
+		}
+		s.packedBits += tag.bits
 	}
+	s.present = newBitmap(s.optional)
+	s.packed = newBitmap(s.packedBits)
 
 	return s
 }
@@ -566,11 +934,36 @@ func newStruct(t reflect.Type, state *state) *Struct {
 type Struct struct {
 	ty      reflect.Type
 	members []structMember
+
+	// optional is the number of members with omitEmpty set, and present is the bitmap of which of them are written.
+	optional int
+	present  bitmap
+
+	// packedBits is the total width of members with bits set, and packed holds them.
+	packedBits int
+	packed     bitmap
+
+	// merge leaves members that weren't written untouched, instead of zeroing them.
+	merge bool
 }
 
 type structMember struct {
 	Encodable
-	offset uintptr
+	offset    uintptr
+	name      string
+	omitEmpty bool
+
+	// bits, if greater than zero, is the width of the bit field the member is packed into.
+	bits int
+}
+
+func (sm structMember) isZero(structPtr unsafe.Pointer) bool {
+	return reflect.NewAt(sm.Type(), unsafe.Pointer(uintptr(structPtr)+sm.offset)).Elem().IsZero()
+}
+
+func (sm structMember) setZero(structPtr unsafe.Pointer) {
+	v := reflect.NewAt(sm.Type(), unsafe.Pointer(uintptr(structPtr)+sm.offset)).Elem()
+	v.Set(reflect.Zero(v.Type()))
 }
 
 func (sm structMember) encodeMember(structPtr unsafe.Pointer, w io.Writer) error {
@@ -583,15 +976,28 @@ func (sm structMember) decodeMember(structPtr unsafe.Pointer, r io.Reader) error
 
 // String implements Encodable
 func (e *Struct) String() string {
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Struct) stringVisiting(visiting map[*Concurrent]bool) string {
 	str := "Struct(" + e.ty.String() + "){"
 
 	if len(e.members) == 0 {
 		return str + "}"
 	}
 
-	str += e.members[0].String()
-	for i := 1; i < len(e.members); i++ {
-		str += ", " + e.members[i].String()
+	for i, m := range e.members {
+		if i > 0 {
+			str += ", "
+		}
+		str += m.name
+		if m.omitEmpty {
+			str += ",omitempty"
+		}
+		if m.bits > 0 {
+			str += ",bits=" + strconv.Itoa(m.bits)
+		}
+		str += ": " + stringOf(m.Encodable, visiting)
 	}
 
 	return str + "}"
@@ -599,7 +1005,11 @@ func (e *Struct) String() string {
 
 // Size implements Sized
 func (e Struct) Size() (size int) {
+	size = len(e.present) + len(e.packed)
 	for _, member := range e.members {
+		if member.bits > 0 {
+			continue
+		}
 		msize := member.Size()
 		if msize < 0 {
 			return -1 << 31
@@ -617,7 +1027,51 @@ func (e Struct) Type() reflect.Type {
 // Encode implements Encodable
 func (e Struct) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
+	if e.optional > 0 {
+		e.present.clear()
+		i := 0
+		for _, m := range e.members {
+			if m.omitEmpty {
+				if !m.isZero(ptr) {
+					e.present.set(i)
+				}
+				i++
+			}
+		}
+		if err := encio.Write(e.present, w); err != nil {
+			return err
+		}
+	}
+
+	if e.packedBits > 0 {
+		e.packed.clear()
+		bit := 0
+		for _, m := range e.members {
+			if m.bits > 0 {
+				v, err := packBits(m.Type(), unsafe.Pointer(uintptr(ptr)+m.offset), m.bits)
+				if err != nil {
+					return err
+				}
+				e.packed.put(bit, m.bits, v)
+				bit += m.bits
+			}
+		}
+		if err := encio.Write(e.packed, w); err != nil {
+			return err
+		}
+	}
+
+	i := 0
 	for _, m := range e.members {
+		if m.bits > 0 {
+			continue
+		}
+		if m.omitEmpty {
+			i++
+			if !e.present.get(i - 1) {
+				continue
+			}
+		}
 		err := m.encodeMember(ptr, w)
 		if err != nil {
 			return err
@@ -629,7 +1083,41 @@ func (e Struct) Encode(ptr unsafe.Pointer, w io.Writer) error {
 // Decode implements Encodable
 func (e Struct) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
+	if e.optional > 0 {
+		if err := encio.Read(e.present, r); err != nil {
+			return err
+		}
+	}
+
+	if e.packedBits > 0 {
+		if err := encio.Read(e.packed, r); err != nil {
+			return err
+		}
+		bit := 0
+		for _, m := range e.members {
+			if m.bits > 0 {
+				if err := unpackBits(m.Type(), unsafe.Pointer(uintptr(ptr)+m.offset), m.bits, e.packed.take(bit, m.bits)); err != nil {
+					return err
+				}
+				bit += m.bits
+			}
+		}
+	}
+
+	i := 0
 	for _, m := range e.members {
+		if m.bits > 0 {
+			continue
+		}
+		if m.omitEmpty {
+			i++
+			if !e.present.get(i - 1) {
+				if !e.merge {
+					m.setZero(ptr)
+				}
+				continue
+			}
+		}
 		err := m.decodeMember(ptr, r)
 		if err != nil {
 			return err
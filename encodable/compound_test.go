@@ -2,12 +2,17 @@ package encodable_test
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/stewi1014/encs/encio"
 	"github.com/stewi1014/encs/encodable"
 )
 
@@ -73,7 +78,7 @@ func TestStruct(t *testing.T) {
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
-			e := encodable.New(reflect.TypeOf(tC.encode), tC.config)
+			e := encodable.MustNew(reflect.TypeOf(tC.encode), tC.config)
 			buff := new(bytes.Buffer)
 
 			enc := reflect.New(reflect.TypeOf(tC.encode)).Elem()
@@ -106,6 +111,446 @@ func TestStruct(t *testing.T) {
 	}
 }
 
+func TestMap(t *testing.T) {
+	m := map[string][]int{
+		"a":     {1, 2, 3},
+		"b":     nil,
+		"hello": {4},
+	}
+
+	e := encodable.MustNew(reflect.TypeOf(m), nil)
+	buff := new(bytes.Buffer)
+
+	if err := e.Encode(unsafe.Pointer(&m), buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	var decoded map[string][]int
+	if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+
+	if !encodable.Equal(e, unsafe.Pointer(&m), unsafe.Pointer(&decoded)) {
+		t.Fatalf("encoded %v, got %v", m, decoded)
+	}
+
+	if buff.Len() != 0 {
+		t.Fatalf("data remaining in buffer %v", buff.Bytes())
+	}
+}
+
+func TestMapReuse(t *testing.T) {
+	m := map[string]int{"a": 1}
+	e := encodable.MustNew(reflect.TypeOf(m), nil)
+	buff := new(bytes.Buffer)
+	if err := e.Encode(unsafe.Pointer(&m), buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	decoded := map[string]int{"b": 2}
+	held := decoded
+	if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("decoded %v, want %v", decoded, m)
+	}
+	if held["a"] != 1 {
+		t.Errorf("map wasn't reused")
+	}
+}
+
+func TestMapReuseNaN(t *testing.T) {
+	m := map[float64]int{1: 1}
+	e := encodable.MustNew(reflect.TypeOf(m), nil)
+	buff := new(bytes.Buffer)
+	if err := e.Encode(unsafe.Pointer(&m), buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	decoded := map[float64]int{math.NaN(): 2}
+	if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(decoded) != 1 || decoded[1] != 1 {
+		t.Errorf("decoded %v, want %v", decoded, m)
+	}
+
+	cloned := map[float64]int{math.NaN(): 2}
+	if err := encodable.Clone(e, unsafe.Pointer(&cloned), unsafe.Pointer(&m)); err != nil {
+		t.Fatalf("clone error: %v", err)
+	}
+	if len(cloned) != 1 || cloned[1] != 1 {
+		t.Errorf("cloned %v, want %v", cloned, m)
+	}
+}
+
+func TestMapTooBig(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		value  interface{}
+		length []byte
+	}{
+		{"Entries", map[int64]int64{}, []byte{0xfb, 0xff, 0xff, 0xff, 0xff}},
+		{"Zero-sized entries", map[struct{}]struct{}{}, []byte{0xfb, 0xff, 0xff, 0xff, 0xff}},
+		// 1<<31 entries of 2 bytes overflows a 32 bit int.
+		{"Negative int", map[int8]int8{}, []byte{0xfb, 0x00, 0x00, 0x00, 0x80}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e := encodable.MustNew(reflect.TypeOf(tC.value), nil)
+
+			buff := bytes.NewBuffer(tC.length)
+			err := e.Decode(unsafe.Pointer(reflect.New(reflect.TypeOf(tC.value)).Pointer()), buff)
+			if !errors.Is(err, encio.ErrMalformed) {
+				t.Errorf("got error %v, want %v", err, encio.ErrMalformed)
+			}
+		})
+	}
+}
+
+type sparseStruct struct {
+	Name    string `encs:"omitempty"`
+	Port    int    `encs:"omitempty"`
+	Verbose bool
+	Tags    []string `encs:"omitempty"`
+	Parent  *sparseStruct
+}
+
+func TestOmitZero(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		config *encodable.Config
+		value  sparseStruct
+		size   int
+	}{
+		{"Tagged zero", nil, sparseStruct{}, 1 + 1 + 1},
+		{"Tagged", nil, sparseStruct{Name: "a", Port: 1}, 1 + 2 + 1 + 1 + 1},
+		{"OmitZero zero", &encodable.Config{OmitZero: true}, sparseStruct{}, 1},
+		{"OmitZero", &encodable.Config{OmitZero: true}, sparseStruct{Verbose: true, Tags: []string{}}, 1 + 1 + 1},
+		{"OmitZero nested", &encodable.Config{OmitZero: true}, sparseStruct{Parent: &sparseStruct{Port: 2}}, 1 + 1 + 1 + 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e := encodable.MustNew(reflect.TypeOf(tC.value), tC.config)
+			buff := new(bytes.Buffer)
+
+			if err := e.Encode(unsafe.Pointer(&tC.value), buff); err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+			if buff.Len() != tC.size {
+				t.Errorf("encoded %v bytes, want %v", buff.Len(), tC.size)
+			}
+
+			// omitted members are zeroed on decode.
+			decoded := sparseStruct{Name: "old", Port: 5, Tags: []string{"old"}}
+			if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if diffs := encodable.Diff(e, unsafe.Pointer(&tC.value), unsafe.Pointer(&decoded)); diffs != nil {
+				t.Errorf("decoded value differs: %v", diffs)
+			}
+			if buff.Len() != 0 {
+				t.Fatalf("data remaining in buffer %v", buff.Bytes())
+			}
+		})
+	}
+}
+
+type flagsStruct struct {
+	A, B, C, D, E, F, G, H, I bool
+	Mode                      uint8 `encs:"bits=3"`
+	Offset                    int   `encs:"bits=4"`
+	Name                      string
+}
+
+func TestPackBits(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		value interface{}
+		size  int
+	}{
+		{"Struct", flagsStruct{A: true, I: true, Mode: 7, Offset: -8, Name: "a"}, 2 + 1 + 1},
+		{"Struct max", flagsStruct{Mode: 5, Offset: 7}, 2 + 1},
+		{"Array", [10]bool{true, 8: true}, 2},
+		{"Slice", []bool{true, false, true}, 1 + 1},
+		{"Slice empty", []bool{}, 1},
+		{"Nested", [][2]bool{{true, false}, {false, true}}, 1 + 1 + 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e := encodable.MustNew(reflect.TypeOf(tC.value), &encodable.Config{PackBits: true})
+			buff := new(bytes.Buffer)
+
+			v := reflect.New(reflect.TypeOf(tC.value))
+			v.Elem().Set(reflect.ValueOf(tC.value))
+			if err := e.Encode(unsafe.Pointer(v.Pointer()), buff); err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+			if buff.Len() != tC.size {
+				t.Errorf("encoded %v bytes, want %v", buff.Len(), tC.size)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(tC.value))
+			if err := e.Decode(unsafe.Pointer(decoded.Pointer()), buff); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if diffs := encodable.Diff(e, unsafe.Pointer(v.Pointer()), unsafe.Pointer(decoded.Pointer())); diffs != nil {
+				t.Errorf("decoded value differs: %v", diffs)
+			}
+			if buff.Len() != 0 {
+				t.Fatalf("data remaining in buffer %v", buff.Bytes())
+			}
+		})
+	}
+}
+
+func TestPackBitsOverflow(t *testing.T) {
+	e := encodable.MustNew(reflect.TypeOf(flagsStruct{}), nil)
+	for _, v := range []flagsStruct{{Mode: 8}, {Offset: 8}, {Offset: -9}} {
+		if err := e.Encode(unsafe.Pointer(&v), ioutil.Discard); !errors.Is(err, encio.ErrOverflow) {
+			t.Errorf("encoding %+v returned %v, want %v", v, err, encio.ErrOverflow)
+		}
+	}
+}
+
+type wideBitsStruct struct {
+	I int     `encs:"bits=40"`
+	U uint    `encs:"bits=64"`
+	P uintptr `encs:"bits=8"`
+}
+
+// wideBitsFixed is encoded the same as wideBitsStruct.
+type wideBitsFixed struct {
+	I int64  `encs:"bits=40"`
+	U uint64 `encs:"bits=64"`
+	P uint8  `encs:"bits=8"`
+}
+
+func TestPackBitsWide(t *testing.T) {
+	// int, uint and uintptr pack as 64 bit integers on every platform.
+	e, err := encodable.New(reflect.TypeOf(wideBitsStruct{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := encodable.MustNew(reflect.TypeOf(wideBitsFixed{}), nil)
+
+	for _, v := range []wideBitsFixed{{I: -5, U: 6, P: 7}, {I: -1 << 39, U: 1 << 40}} {
+		buff := new(bytes.Buffer)
+		if err := fixed.Encode(unsafe.Pointer(&v), buff); err != nil {
+			t.Fatalf("encoding %+v: %v", v, err)
+		}
+
+		var decoded wideBitsStruct
+		err := e.Decode(unsafe.Pointer(&decoded), buff)
+		if v.I != int64(int(v.I)) || v.U != uint64(uint(v.U)) {
+			// too wide for a 32 bit platform.
+			if !errors.Is(err, encio.ErrOverflow) {
+				t.Errorf("decoding %+v returned %v, want %v", v, err, encio.ErrOverflow)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("decoding %+v: %v", v, err)
+		}
+		if int64(decoded.I) != v.I || uint64(decoded.U) != v.U || uint8(decoded.P) != v.P {
+			t.Errorf("decoded %+v, want %+v", decoded, v)
+		}
+	}
+}
+
+type mergeStruct struct {
+	Name  string `encs:"omitempty"`
+	Tags  []string
+	Attrs map[string][]int
+	Inner *mergeStruct
+}
+
+func TestMerge(t *testing.T) {
+	config := &encodable.Config{Merge: true}
+	e := encodable.MustNew(reflect.TypeOf(mergeStruct{}), config)
+
+	patch := mergeStruct{
+		Tags:  []string{"c"},
+		Attrs: map[string][]int{"a": {3}, "b": {4}},
+		Inner: &mergeStruct{Name: "inner", Tags: []string{"y"}},
+	}
+	newExisting := func() mergeStruct {
+		return mergeStruct{
+			Name:  "existing",
+			Tags:  []string{"a", "b"},
+			Attrs: map[string][]int{"a": {1, 2}, "z": {0}},
+			Inner: &mergeStruct{Tags: []string{"x"}},
+		}
+	}
+	want := mergeStruct{
+		Name:  "existing",
+		Tags:  []string{"a", "b", "c"},
+		Attrs: map[string][]int{"a": {1, 2, 3}, "b": {4}, "z": {0}},
+		Inner: &mergeStruct{Name: "inner", Tags: []string{"x", "y"}, Attrs: map[string][]int{}},
+	}
+
+	buff := new(bytes.Buffer)
+	if err := e.Encode(unsafe.Pointer(&patch), buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	decoded := newExisting()
+	inner := decoded.Inner
+	if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %+v, want %+v", decoded, want)
+	}
+	if decoded.Inner != inner {
+		t.Errorf("pointed-to value wasn't reused")
+	}
+
+	cloned := newExisting()
+	if err := encodable.Clone(e, unsafe.Pointer(&cloned), unsafe.Pointer(&patch)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cloned, want) {
+		t.Errorf("cloned %+v, want %+v", cloned, want)
+	}
+}
+
+type recursiveStruct struct {
+	Name     string
+	Children []*recursiveStruct
+	Parent   *recursiveStruct
+	depth    int
+}
+
+type handleStruct struct {
+	Name     string `api:"name"`
+	Done     chan struct{}
+	Callback func()
+	Raw      unsafe.Pointer
+	Hooks    []func()
+}
+
+func TestKindPolicy(t *testing.T) {
+	ty := reflect.TypeOf(handleStruct{})
+	if _, err := encodable.New(ty, nil); !errors.Is(err, encio.ErrBadType) {
+		t.Fatalf("creating encodable with default policies; got error %v, want %v", err, encio.ErrBadType)
+	}
+
+	testCases := []struct {
+		desc   string
+		config *encodable.Config
+		fields []string
+	}{
+		{"Skip", &encodable.Config{ChanPolicy: encodable.PolicySkip, FuncPolicy: encodable.PolicySkip, UnsafePointerPolicy: encodable.PolicySkip}, []string{"Name", "Hooks"}},
+		{"Nil", &encodable.Config{ChanPolicy: encodable.PolicyNil, FuncPolicy: encodable.PolicyNil, UnsafePointerPolicy: encodable.PolicyNil}, []string{"Name", "Done", "Callback", "Raw", "Hooks"}},
+		{"StructTag", &encodable.Config{StructTag: "api"}, []string{"Name"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e, err := encodable.New(ty, tC.config)
+			if err != nil {
+				t.Fatalf("error creating encodable: %v", err)
+			}
+
+			str := e.String()
+			for _, f := range []string{"Name", "Done", "Callback", "Raw", "Hooks"} {
+				want := false
+				for _, field := range tC.fields {
+					want = want || field == f
+				}
+				if got := strings.Contains(str, f+":"); got != want {
+					t.Errorf("%v has field %v: %v, want %v", str, f, got, want)
+				}
+			}
+
+			x := 1
+			value := handleStruct{
+				Name:     "a",
+				Done:     make(chan struct{}),
+				Callback: func() {},
+				Raw:      unsafe.Pointer(&x),
+				Hooks:    []func(){func() {}},
+			}
+			buff := new(bytes.Buffer)
+			if err := e.Encode(unsafe.Pointer(&value), buff); err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+			checkSize(buff, e, t)
+
+			var decoded handleStruct
+			if err := e.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if decoded.Name != value.Name || decoded.Done != nil || decoded.Callback != nil || decoded.Raw != nil {
+				t.Errorf("decoded %+v from %+v", decoded, value)
+			}
+			if wantHooks := strings.Contains(str, "Hooks:"); wantHooks && (len(decoded.Hooks) != 1 || decoded.Hooks[0] != nil) {
+				t.Errorf("decoded hooks %v, want [nil]", decoded.Hooks)
+			}
+			if buff.Len() != 0 {
+				t.Fatalf("data remaining in buffer %v", buff.Bytes())
+			}
+		})
+	}
+}
+
+func TestKindPolicyInterface(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	if err := resolver.Register(make(chan int)); err != nil {
+		t.Fatal(err)
+	}
+	e := encodable.MustNew(reflect.TypeOf((*interface{})(nil)).Elem(), &encodable.Config{Resolver: resolver})
+
+	var value interface{} = make(chan int)
+	buff := new(bytes.Buffer)
+	if err := e.Encode(unsafe.Pointer(&value), buff); !errors.Is(err, encio.ErrBadType) {
+		t.Errorf("encoding interface holding %T; got error %v, want %v", value, err, encio.ErrBadType)
+	}
+	if buff.Len() != 0 {
+		t.Errorf("failed encode wrote %v", buff.Bytes())
+	}
+}
+
+func TestRecursiveString(t *testing.T) {
+	ty := reflect.TypeOf(recursiveStruct{})
+	str := encodable.MustNew(ty, nil).String()
+
+	if again := encodable.MustNew(ty, nil).String(); again != str {
+		t.Fatalf("String differs between Encodables for the same type; %v and %v", str, again)
+	}
+
+	if other := encodable.MustNew(ty, &encodable.Config{IncludeUnexported: true}).String(); other == str {
+		t.Fatalf("String doesn't change with IncludeUnexported; %v", str)
+	}
+}
+
+func TestRecursiveStringConcurrent(t *testing.T) {
+	enc := encodable.MustNew(reflect.TypeOf(recursiveStruct{}), nil)
+	want := enc.String()
+
+	var wg sync.WaitGroup
+	strs := make([]string, 8)
+	for i := range strs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000 && strs[i] == ""; j++ {
+				if str := enc.String(); str != want {
+					strs[i] = str
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, str := range strs {
+		if str != "" {
+			t.Fatalf("String differs when called concurrently; %v, want %v", str, want)
+		}
+	}
+}
+
 func BenchmarkStructEncode(b *testing.B) {
 	benchStruct := TestStruct2{
 		Name:     "9b899bec35bc6bb8",
@@ -116,7 +561,10 @@ func BenchmarkStructEncode(b *testing.B) {
 		Money:    0.16683100555848812,
 	}
 
-	enc := encodable.NewStruct(reflect.TypeOf(benchStruct), nil)
+	enc, err := encodable.NewStruct(reflect.TypeOf(benchStruct), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
 	for i := 0; i < b.N; i++ {
 		enc.Encode(unsafe.Pointer(&benchStruct), ioutil.Discard)
 	}
@@ -132,7 +580,10 @@ func BenchmarkStructDecode(b *testing.B) {
 		Money:    0.16683100555848812,
 	}
 
-	enc := encodable.NewStruct(reflect.TypeOf(benchStruct), nil)
+	enc, err := encodable.NewStruct(reflect.TypeOf(benchStruct), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
 	buff := new(buffer)
 	if err := enc.Encode(unsafe.Pointer(&benchStruct), buff); err != nil {
 		b.Fatal(err)
@@ -43,12 +43,39 @@ func (e *Concurrent) Type() reflect.Type {
 	return enc.Type()
 }
 
-// String implements Encodable
+// String implements Encodable.
+// Encodables for recursive types contain themselves; inner occurrences are shown as Concurrent(Recursive <type>).
 func (e *Concurrent) String() string {
+	return e.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (e *Concurrent) stringVisiting(visiting map[*Concurrent]bool) string {
+	if visiting[e] {
+		return fmt.Sprintf("Concurrent(Recursive %v)", e.Type())
+	}
+	visiting[e] = true
+	defer delete(visiting, e)
+
 	enc := e.get()
 	defer e.put(enc)
 
-	return fmt.Sprintf("Concurrent(%v)", enc.String())
+	return fmt.Sprintf("Concurrent(%v)", stringOf(enc, visiting))
+}
+
+// recursiveStringer is implemented by Encodables that contain other Encodables.
+// stringVisiting returns the same as String, passing visiting on to the Encodables it contains.
+// visiting holds the Concurrents being printed by the current call to String,
+// so recursive types aren't printed forever, without keeping state in Encodables that may be used concurrently.
+type recursiveStringer interface {
+	stringVisiting(visiting map[*Concurrent]bool) string
+}
+
+// stringOf returns the String of enc, passing visiting on if it contains other Encodables.
+func stringOf(enc Encodable, visiting map[*Concurrent]bool) string {
+	if s, ok := enc.(recursiveStringer); ok {
+		return s.stringVisiting(visiting)
+	}
+	return enc.String()
 }
 
 // Encode implements Encodable
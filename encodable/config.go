@@ -1,6 +1,9 @@
 package encodable
 
-import "reflect"
+import (
+	"reflect"
+	"strconv"
+)
 
 // Config contains settings and information for the generation of a new Encodable.
 // Some Encodables do nothing with Config, and some require information from it.
@@ -18,12 +21,117 @@ type Config struct {
 
 	// If StructTag is set, only struct fields with the given tag will be encoded
 	StructTag string
+
+	// ChanPolicy, FuncPolicy and UnsafePointerPolicy are how channels, funcs and unsafe.Pointers, which can't be encoded, are handled.
+	// By default New returns an error for types that hold them.
+	ChanPolicy          KindPolicy
+	FuncPolicy          KindPolicy
+	UnsafePointerPolicy KindPolicy
+
+	// Canonical makes encoding deterministic, so the same value always encodes to the same bytes;
+	// useful for hashing, signing and deduplicating encoded data.
+	// Map entries are normally written in Go's random iteration order. In canonical mode they are sorted by their encoded form,
+	// which also fixes the order in which references inside maps are resolved.
+	// Other Encodables are already deterministic, with the exception of BinaryMarshaler, which is only as deterministic as the type's MarshalBinary.
+	// Canonical data can be decoded with or without Canonical set.
+	Canonical bool
+
+	// TreeShaped assumes that encoded values are trees; that no two pointers or interfaces in a value point to the same place.
+	// Pointers are then encoded without a reference table, saving a lookup per pointer.
+	// Values that do share pointers are encoded once per pointer, and decode to copies that no longer share memory.
+	// Values with cycles must not be encoded; they recurse until the stack overflows.
+	// Types whose values can't share pointers, such as those with only one pointer and no interfaces, are encoded this way regardless.
+	TreeShaped bool
+
+	// Aliasing preserves sharing of slices and maps, as is always done for pointers.
+	// Slices sharing a backing array, and maps held in more than one place, decode sharing memory in the same way,
+	// and nil slices and maps decode as nil.
+	// Slices are written up to their capacity, so that later slices of the same backing array can be written as references to it.
+	// A slice is only found in an earlier backing array if both end at the same place, as slices made with s[i:j] do, and it starts within it.
+	// It has no effect with TreeShaped.
+	Aliasing bool
+
+	// OmitZero writes a bitmap of which members of a struct are non-zero before its members, and leaves out the members that are zero,
+	// which decode as zero. It shrinks structs where most members are usually zero, at the cost of a bit per member.
+	// Struct fields tagged `encs:"omitempty"` are encoded this way regardless.
+	OmitZero bool
+
+	// PackBits writes bool struct members, and the elements of bool slices and arrays, as single bits.
+	// The bool members of a struct are packed together with its fields tagged `encs:"bits=N"` (see Struct),
+	// and bool slices and arrays are packed eight elements to a byte.
+	// Slices with Aliasing are not packed.
+	PackBits bool
+
+	// Merge makes Decode merge the decoded value into the existing value, instead of replacing it.
+	// Entries decoded into a non-nil map are added to it, with the values for keys that are already present decoded into a copy of the existing value;
+	// elements decoded into a slice are appended to it; and struct members left out by OmitZero or omitempty keep their existing values.
+	// Pointers, interfaces holding the decoded type, arrays and struct members are always decoded into in place, so their contents are merged too.
+	// Without Merge, maps and slices are replaced; their memory is reused, but their old contents are not kept.
+	// Merge has no effect on slices and maps with Aliasing, which are always replaced.
+	// It doesn't change the encoded form, so Encoder and Decoder needn't agree on it.
+	Merge bool
+
+	// References, if set, is used as the reference table instead of one that is emptied at the start of every Encode and Decode,
+	// so that references can be made to values written in earlier Encodes. See References.
+	// Encodables with the same References must all be used for encoding, or all for decoding.
+	// It has no effect with TreeShaped.
+	References *References
+}
+
+// KindPolicy decides how values of a kind that can't be encoded are handled.
+type KindPolicy int
+
+const (
+	// PolicyError makes New return an encio.ErrBadType error for types that hold values of the kind.
+	PolicyError KindPolicy = iota
+
+	// PolicySkip leaves struct fields of the kind out of the encoded struct, as if they were unexported.
+	// Values of the kind held in other ways, such as the elements of a slice, are handled as with PolicyNil.
+	PolicySkip
+
+	// PolicyNil encodes values of the kind as nothing, decoding them as nil.
+	PolicyNil
+)
+
+// String implements fmt.Stringer
+func (p KindPolicy) String() string {
+	switch p {
+	case PolicyError:
+		return "PolicyError"
+	case PolicySkip:
+		return "PolicySkip"
+	case PolicyNil:
+		return "PolicyNil"
+	default:
+		return "KindPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// policy returns the KindPolicy for values of the given kind. Kinds that can be encoded have none, and return PolicyError.
+func (c *Config) policy(kind reflect.Kind) KindPolicy {
+	switch kind {
+	case reflect.Chan:
+		return c.ChanPolicy
+	case reflect.Func:
+		return c.FuncPolicy
+	case reflect.UnsafePointer:
+		return c.UnsafePointerPolicy
+	default:
+		return PolicyError
+	}
 }
 
 // String returns a string unique to the given configuration.
-// Format is Config(options, StructTag: <StructTag>, Resolver: <Resolver>).
+// Format is Config(options, StructTag: <StructTag>, Chan: <ChanPolicy>, Func: <FuncPolicy>, UnsafePointer: <UnsafePointerPolicy>,
+// References: <References.Limit>, Resolver: <Resolver>), where policies are only shown if they aren't PolicyError.
 // Options are
 // - u for IncludeUnexported
+// - c for Canonical
+// - t for TreeShaped
+// - a for Aliasing
+// - z for OmitZero
+// - p for PackBits
+// - m for Merge
 func (c *Config) String() string {
 	// the main point here is to be concice over descriptive, speed is not of great concern either.
 	// the string should uniquely represent the config, but should be as human-readable as is reasonable without cluttering the screen.
@@ -40,6 +148,24 @@ func (c *Config) String() string {
 	if c.IncludeUnexported {
 		elements[0] += "u"
 	}
+	if c.Canonical {
+		elements[0] += "c"
+	}
+	if c.TreeShaped {
+		elements[0] += "t"
+	}
+	if c.Aliasing {
+		elements[0] += "a"
+	}
+	if c.OmitZero {
+		elements[0] += "z"
+	}
+	if c.PackBits {
+		elements[0] += "p"
+	}
+	if c.Merge {
+		elements[0] += "m"
+	}
 
 	// other info
 
@@ -47,30 +173,51 @@ func (c *Config) String() string {
 		elements = append(elements, "StructTag: "+c.StructTag)
 	}
 
+	if c.ChanPolicy != PolicyError {
+		elements = append(elements, "Chan: "+c.ChanPolicy.String())
+	}
+	if c.FuncPolicy != PolicyError {
+		elements = append(elements, "Func: "+c.FuncPolicy.String())
+	}
+	if c.UnsafePointerPolicy != PolicyError {
+		elements = append(elements, "UnsafePointer: "+c.UnsafePointerPolicy.String())
+	}
+
+	if c.References != nil {
+		elements = append(elements, "References: "+strconv.Itoa(c.References.Limit()))
+	}
+
 	if c.Resolver != nil {
 		elements = append(elements, "Resolver: "+Name(reflect.TypeOf(c.Resolver)))
 	}
 
-	str := "Config("
-	if elements[0] != "" {
-		str += " " + elements[0]
-	}
-	for i := 1; i < len(elements); i++ {
-		if elements[i] != "" {
-			str += ", " + elements[i]
+	// elements[0] is empty when no options are set, and mustn't leave a separator behind.
+	str, sep := "Config(", " "
+	for _, element := range elements {
+		if element != "" {
+			str += sep + element
+			sep = ", "
 		}
 	}
 	str += ")"
 	return str
 }
 
-func (c *Config) genState() *state {
-	s := &state{}
+// genState returns the state for a new Encodable tree encoding t.
+func (c *Config) genState(t reflect.Type) *state {
+	s := &state{
+		encoders: make(map[reflect.Type]*Concurrent),
+	}
 
 	if c != nil {
 		s.Config = *c
 	}
 
+	// values can always share pointers with values in earlier Encodes.
+	s.tree = s.TreeShaped || (s.References == nil && !canReference(t, &s.Config))
+	if !s.tree {
+		s.r = newReferencer(s.References)
+	}
 	return s
 }
 
@@ -87,20 +234,41 @@ func (c *Config) copy() *Config {
 type state struct {
 	Config
 
-	// referencer is created by the first Encodable that asks for it.
+	// encoders is a map of Encodables for given types. used to prevent infinite recursion when resolving recursive types.
+	encoders map[reflect.Type]*Concurrent
+
+	// tree is set when pointers and interfaces don't need a referencer;
+	// either Config.TreeShaped is set, or the type can't hold references.
+	tree bool
+
+	// r is the referencer shared by the Encodables in the tree, or nil if tree is set.
 	r *referencer
 }
 
-// callers *must* return Encodable as their new Encodable.
-func (s *state) referencer(enc Encodable) (*referencer, Encodable) {
+// newEncodable returns the concurrent-safe encodable for the type t, creating if needed.
+// that is, a recursive-safe Encodable. Recursive types *must* use this instead of NewEncodable when resolving
+// their element types, else they loop to infinity.
+// also, they must be concurrent safe on the off chance that a type embeds itself, and calls itself from inside Encode and Decode.
+func (s *state) newEncodable(t reflect.Type) Encodable {
+	if enc, ok := s.encoders[t]; ok {
+		return enc
+	}
+
+	enc := NewConcurrent(func() Encodable {
+		return newEncodable(t, s)
+	})
+	s.encoders[t] = enc
+	return enc
+}
+
+// root returns the Encodable to use for the top-level Encodable enc, wrapping it with the referencer if there is one.
+// New* functions *must* return it as their new Encodable.
+func (s *state) root(enc Encodable) Encodable {
 	if s.r == nil {
-		s.r = &referencer{
-			encoders: make(map[reflect.Type]*Concurrent),
-			enc:      enc,
-		}
-		return s.r, s.r
+		return enc
 	}
-	return s.r, enc
+	s.r.enc = enc
+	return s.r
 }
 
 /*
@@ -0,0 +1,477 @@
+package encodable
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+)
+
+// EncodeDelta writes the changes from the value at old to the value at new, walking enc's tree of Encodables.
+// DecodeDelta applies them to a copy of the old value, making it the same as if new had been encoded and decoded.
+//
+// Which members of structs and elements of arrays and slices have changed is written as a bitmap, followed by the changes to them.
+// Maps are written as the keys that were removed, followed by the entries that were added or changed.
+// Other values, such as pointers, interfaces and slices and maps with Config.Aliasing, are written in full when they change,
+// which is decided with Equal.
+// The values are walked once; the changes are buffered until it is known which members changed.
+//
+// old and new must be pointers to values of enc's type.
+func EncodeDelta(enc Encodable, old, new unsafe.Pointer, w io.Writer) error {
+	checkPtr(old)
+	checkPtr(new)
+
+	d := &deltaEncoder{}
+	return d.root(enc, old, new, w)
+}
+
+// DecodeDelta reads changes written by EncodeDelta, applying them to the value at ptr,
+// which must be the same as the old value given to EncodeDelta.
+// If it returns an error, the value at ptr may have been partly changed.
+//
+// ptr must be a pointer to a value of enc's type.
+func DecodeDelta(enc Encodable, ptr unsafe.Pointer, r io.Reader) error {
+	checkPtr(ptr)
+
+	d := &deltaDecoder{r: r}
+	return d.root(enc, ptr)
+}
+
+// deltaEncoder walks Encodable trees, writing the changes between two values.
+type deltaEncoder struct {
+	len encio.Uvarint
+
+	// buffers hold the changes below each level of the walk, until it's known whether anything changed.
+	buffers []*bytes.Buffer
+	depth   int
+}
+
+// root writes whether old and new differ, followed by the changes from old to new.
+func (d *deltaEncoder) root(enc Encodable, old, new unsafe.Pointer, w io.Writer) error {
+	if ref, ok := enc.(*referencer); ok {
+		mark := ref.begin()
+		err := d.root(ref.enc, old, new, w)
+		ref.end(mark, err)
+		return err
+	}
+
+	changes := d.buffer()
+	defer d.release()
+
+	changed := newBitmap(1)
+	differs, err := d.delta(enc, old, new, changes)
+	if err != nil {
+		return err
+	}
+	if differs {
+		changed.set(0)
+	}
+	return d.write(changed, changes, w)
+}
+
+// buffer returns an empty buffer for the current level of the walk. release must be called when it is no longer used.
+func (d *deltaEncoder) buffer() *bytes.Buffer {
+	if d.depth == len(d.buffers) {
+		d.buffers = append(d.buffers, new(bytes.Buffer))
+	}
+	buff := d.buffers[d.depth]
+	buff.Reset()
+	d.depth++
+	return buff
+}
+
+// release frees the buffer returned by the last call to buffer.
+func (d *deltaEncoder) release() {
+	d.depth--
+}
+
+// write writes the bitmap changed, followed by the buffered changes.
+func (d *deltaEncoder) write(changed bitmap, changes *bytes.Buffer, w io.Writer) error {
+	if err := encio.Write(changed, w); err != nil {
+		return err
+	}
+	return encio.Write(changes.Bytes(), w)
+}
+
+// delta writes the changes from old to new, returning whether there were any.
+// If there weren't, nothing is written.
+func (d *deltaEncoder) delta(enc Encodable, old, new unsafe.Pointer, w io.Writer) (bool, error) {
+	switch e := enc.(type) {
+	case *Concurrent:
+		c := e.get()
+		defer e.put(c)
+		return d.delta(c, old, new, w)
+
+	case *Struct:
+		changes := d.buffer()
+		defer d.release()
+
+		changed, differs := newBitmap(len(e.members)), false
+		for i, m := range e.members {
+			c, err := d.delta(m.Encodable, unsafe.Pointer(uintptr(old)+m.offset), unsafe.Pointer(uintptr(new)+m.offset), changes)
+			if err != nil {
+				return false, err
+			}
+			if c {
+				changed.set(i)
+				differs = true
+			}
+		}
+		if !differs {
+			return false, nil
+		}
+		return true, d.write(changed, changes, w)
+
+	case *Array:
+		changes := d.buffer()
+		defer d.release()
+
+		changed, differs, err := d.elements(e.elem, old, new, int(e.len), changes)
+		if err != nil || !differs {
+			return false, err
+		}
+		return true, d.write(changed, changes, w)
+
+	case *Slice:
+		if e.r == nil {
+			return d.slice(e, old, new, w)
+		}
+
+	case *Map:
+		if e.r == nil {
+			return d.mapping(e, old, new, w)
+		}
+	}
+
+	if Equal(enc, old, new) {
+		return false, nil
+	}
+	return true, enc.Encode(new, w)
+}
+
+// elements writes the changes to the first n elements of the arrays at old and new to changes,
+// returning a bitmap of the elements that differ, and whether any did.
+func (d *deltaEncoder) elements(elem Encodable, old, new unsafe.Pointer, n int, changes io.Writer) (bitmap, bool, error) {
+	size := elem.Type().Size()
+	changed, differs := newBitmap(n), false
+	for i := 0; i < n; i++ {
+		c, err := d.delta(elem, unsafe.Pointer(uintptr(old)+uintptr(i)*size), unsafe.Pointer(uintptr(new)+uintptr(i)*size), changes)
+		if err != nil {
+			return nil, false, err
+		}
+		if c {
+			changed.set(i)
+			differs = true
+		}
+	}
+	return changed, differs, nil
+}
+
+// slice writes the new length, the changes to the elements both slices have, and then the new elements in full.
+func (d *deltaEncoder) slice(e *Slice, old, new unsafe.Pointer, w io.Writer) (bool, error) {
+	os, ns := (*sliceHeader)(old), (*sliceHeader)(new)
+
+	common := os.len
+	if ns.len < common {
+		common = ns.len
+	}
+
+	changes := d.buffer()
+	defer d.release()
+
+	changed, differs, err := d.elements(e.elem, os.data, ns.data, common, changes)
+	if err != nil {
+		return false, err
+	}
+	if !differs && os.len == ns.len {
+		return false, nil
+	}
+
+	if err := d.len.Encode(w, uint32(ns.len)); err != nil {
+		return false, err
+	}
+	if err := d.write(changed, changes, w); err != nil {
+		return false, err
+	}
+
+	size := e.t.Elem().Size()
+	for i := common; i < ns.len; i++ {
+		if err := e.elem.Encode(unsafe.Pointer(uintptr(ns.data)+uintptr(i)*size), w); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// mapping writes the keys that were removed, then the entries that were added or changed;
+// the key, and then either the changes to the value or, for new keys, the value in full.
+func (d *deltaEncoder) mapping(e *Map, old, new unsafe.Pointer, w io.Writer) (bool, error) {
+	om, nm := reflect.NewAt(e.t, old).Elem(), reflect.NewAt(e.t, new).Elem()
+
+	// map keys and values aren't addressable; work on copies.
+	key, oval, nval := reflect.New(e.t.Key()), reflect.New(e.t.Elem()), reflect.New(e.t.Elem())
+
+	removed := d.buffer()
+	defer d.release()
+
+	var nremoved int
+	iter := om.MapRange()
+	for iter.Next() {
+		if nm.MapIndex(iter.Key()).IsValid() {
+			continue
+		}
+		key.Elem().Set(iter.Key())
+		if err := e.key.Encode(unsafe.Pointer(key.Pointer()), removed); err != nil {
+			return false, err
+		}
+		nremoved++
+	}
+
+	// entries are only known to have changed once their value has been walked,
+	// so the keys of unchanged entries, and the references made writing them, are taken back.
+	updates := d.buffer()
+	defer d.release()
+
+	var nupdated int
+	var added []reflect.Value
+	iter = nm.MapRange()
+	for iter.Next() {
+		ov := om.MapIndex(iter.Key())
+		if !ov.IsValid() {
+			added = append(added, iter.Key())
+			continue
+		}
+
+		start, mark := updates.Len(), 0
+		if e.state.r != nil {
+			mark = e.state.r.mark()
+		}
+
+		key.Elem().Set(iter.Key())
+		if err := e.key.Encode(unsafe.Pointer(key.Pointer()), updates); err != nil {
+			return false, err
+		}
+		oval.Elem().Set(ov)
+		nval.Elem().Set(iter.Value())
+		changed, err := d.delta(e.val, unsafe.Pointer(oval.Pointer()), unsafe.Pointer(nval.Pointer()), updates)
+		if err != nil {
+			return false, err
+		}
+		if !changed {
+			updates.Truncate(start)
+			if e.state.r != nil {
+				e.state.r.truncate(mark)
+			}
+			continue
+		}
+		nupdated++
+	}
+	for _, k := range added {
+		key.Elem().Set(k)
+		nval.Elem().Set(nm.MapIndex(k))
+		if err := e.key.Encode(unsafe.Pointer(key.Pointer()), updates); err != nil {
+			return false, err
+		}
+		if err := e.val.Encode(unsafe.Pointer(nval.Pointer()), updates); err != nil {
+			return false, err
+		}
+		nupdated++
+	}
+
+	if nremoved == 0 && nupdated == 0 {
+		return false, nil
+	}
+
+	if err := d.len.Encode(w, uint32(nremoved)); err != nil {
+		return false, err
+	}
+	if err := encio.Write(removed.Bytes(), w); err != nil {
+		return false, err
+	}
+	if err := d.len.Encode(w, uint32(nupdated)); err != nil {
+		return false, err
+	}
+	return true, encio.Write(updates.Bytes(), w)
+}
+
+// deltaDecoder walks Encodable trees, applying changes written by deltaEncoder.
+type deltaDecoder struct {
+	r   io.Reader
+	len encio.Uvarint
+}
+
+// root reads whether the value changed, applying the changes to the value at ptr if it did.
+func (d *deltaDecoder) root(enc Encodable, ptr unsafe.Pointer) error {
+	if ref, ok := enc.(*referencer); ok {
+		mark := ref.begin()
+		err := d.root(ref.enc, ptr)
+		ref.end(mark, err)
+		return err
+	}
+
+	changed := newBitmap(1)
+	if err := encio.Read(changed, d.r); err != nil {
+		return err
+	}
+	if !changed.get(0) {
+		return nil
+	}
+	return d.delta(enc, ptr)
+}
+
+// delta applies changes to the value at ptr.
+func (d *deltaDecoder) delta(enc Encodable, ptr unsafe.Pointer) error {
+	switch e := enc.(type) {
+	case *Concurrent:
+		c := e.get()
+		defer e.put(c)
+		return d.delta(c, ptr)
+
+	case *Struct:
+		changed := newBitmap(len(e.members))
+		if err := encio.Read(changed, d.r); err != nil {
+			return err
+		}
+		for i, m := range e.members {
+			if changed.get(i) {
+				if err := d.delta(m.Encodable, unsafe.Pointer(uintptr(ptr)+m.offset)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case *Array:
+		return d.elements(e.elem, ptr, int(e.len))
+
+	case *Slice:
+		if e.r == nil {
+			return d.slice(e, ptr)
+		}
+
+	case *Map:
+		if e.r == nil {
+			return d.mapping(e, ptr)
+		}
+	}
+
+	// values written in full are decoded into a new value, rather than into the old one,
+	// whose pointees may still be shared with parts of the value that haven't changed.
+	v := reflect.New(enc.Type())
+	if err := enc.Decode(unsafe.Pointer(v.Pointer()), d.r); err != nil {
+		return err
+	}
+	reflect.NewAt(enc.Type(), ptr).Elem().Set(v.Elem())
+	return nil
+}
+
+// elements applies changes to the first n elements of the array at ptr.
+func (d *deltaDecoder) elements(elem Encodable, ptr unsafe.Pointer, n int) error {
+	size := elem.Type().Size()
+	changed := newBitmap(n)
+	if err := encio.Read(changed, d.r); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if changed.get(i) {
+			if err := d.delta(elem, unsafe.Pointer(uintptr(ptr)+uintptr(i)*size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *deltaDecoder) slice(e *Slice, ptr unsafe.Pointer) error {
+	l32, err := d.len.Decode(d.r)
+	if err != nil {
+		return err
+	}
+
+	// zero-sized elements still take time to decode.
+	// l32 is checked before it is converted to int, which can't hold it on 32 bit hosts.
+	size, limit := e.t.Elem().Size(), uint64(encio.TooBig)
+	if size > 0 {
+		limit /= uint64(size)
+	}
+	if uint64(l32) > limit {
+		return encio.NewIOError(encio.ErrMalformed, d.r, fmt.Sprintf("slice of length %v (%v bytes each) is too big", l32, size), 0)
+	}
+	l := int(l32)
+
+	slice := reflect.NewAt(e.t, ptr).Elem()
+	common := slice.Len()
+	if l < common {
+		common = l
+	}
+
+	if slice.Cap() < l {
+		grown := reflect.MakeSlice(e.t, l, l)
+		reflect.Copy(grown, slice)
+		slice.Set(grown)
+	} else {
+		slice.SetLen(l)
+	}
+
+	data := (*sliceHeader)(ptr).data
+	if err := d.elements(e.elem, data, common); err != nil {
+		return err
+	}
+	// the backing array can hold elements left from a longer slice; they are cleared for the same reason.
+	zero := reflect.Zero(e.t.Elem())
+	for i := common; i < l; i++ {
+		slice.Index(i).Set(zero)
+		if err := e.elem.Decode(unsafe.Pointer(uintptr(data)+uintptr(i)*size), d.r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *deltaDecoder) mapping(e *Map, ptr unsafe.Pointer) error {
+	m := reflect.NewAt(e.t, ptr).Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(e.t))
+	}
+
+	removed, err := e.decodeLen(d.r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < removed; i++ {
+		key := reflect.New(e.t.Key())
+		if err := e.key.Decode(unsafe.Pointer(key.Pointer()), d.r); err != nil {
+			return err
+		}
+		m.SetMapIndex(key.Elem(), reflect.Value{})
+	}
+
+	updated, err := e.decodeLen(d.r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < updated; i++ {
+		key := reflect.New(e.t.Key())
+		if err := e.key.Decode(unsafe.Pointer(key.Pointer()), d.r); err != nil {
+			return err
+		}
+
+		// map values aren't addressable; work on a copy.
+		val := reflect.New(e.t.Elem())
+		if existing := m.MapIndex(key.Elem()); existing.IsValid() {
+			val.Elem().Set(existing)
+			err = d.delta(e.val, unsafe.Pointer(val.Pointer()))
+		} else {
+			err = e.val.Decode(unsafe.Pointer(val.Pointer()), d.r)
+		}
+		if err != nil {
+			return err
+		}
+
+		m.SetMapIndex(key.Elem(), val.Elem())
+	}
+	return nil
+}
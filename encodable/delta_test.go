@@ -0,0 +1,128 @@
+package encodable_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+type deltaInner struct {
+	A int
+	B string
+}
+
+type deltaPair struct {
+	P, Q *deltaInner
+}
+
+func TestDelta(t *testing.T) {
+	shared := &deltaInner{A: 1}
+	testCases := []struct {
+		old, new interface{}
+	}{
+		{1, 2},
+		{"same", "same"},
+		{deltaInner{1, "a"}, deltaInner{1, "b"}},
+		{[3]deltaInner{{A: 1}}, [3]deltaInner{{A: 1}, {A: 2}}},
+		{[]int{1, 2, 3}, []int{1, 5}},
+		{[]int{1}, []int{2, 3, 4}},
+		{[]int(nil), []int{1}},
+		{map[int]deltaInner{1: {A: 1}, 2: {A: 2}}, map[int]deltaInner{2: {A: 2, B: "x"}, 3: {A: 3}}},
+		{map[string]int(nil), map[string]int{"a": 1}},
+		{[]map[string][]int{{"a": {1}}}, []map[string][]int{{"a": {1, 2}}, nil}},
+		{deltaPair{shared, shared}, deltaPair{&deltaInner{A: 2}, shared}},
+	}
+	for _, tC := range testCases {
+		ty := reflect.TypeOf(tC.old)
+		t.Run(fmt.Sprintf("%v %v to %v", ty, tC.old, tC.new), func(t *testing.T) {
+			enc := encodable.MustNew(ty, nil)
+
+			from, to := reflect.New(ty), reflect.New(ty)
+			from.Elem().Set(reflect.ValueOf(tC.old))
+			to.Elem().Set(reflect.ValueOf(tC.new))
+
+			buff := new(bytes.Buffer)
+			if err := encodable.EncodeDelta(enc, unsafe.Pointer(from.Pointer()), unsafe.Pointer(to.Pointer()), buff); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := reflect.New(ty)
+			if err := encodable.Clone(enc, unsafe.Pointer(decoded.Pointer()), unsafe.Pointer(from.Pointer())); err != nil {
+				t.Fatal(err)
+			}
+			if err := encodable.DecodeDelta(enc, unsafe.Pointer(decoded.Pointer()), buff); err != nil {
+				t.Fatal(err)
+			}
+
+			if diffs := encodable.Diff(enc, unsafe.Pointer(to.Pointer()), unsafe.Pointer(decoded.Pointer())); diffs != nil {
+				t.Errorf("decoded value differs: %v", diffs)
+			}
+			if buff.Len() != 0 {
+				t.Errorf("%v bytes remaining after decode", buff.Len())
+			}
+		})
+	}
+}
+
+type deltaShared struct {
+	M map[string]*deltaInner
+	P *deltaInner
+}
+
+func TestDeltaReferences(t *testing.T) {
+	enc := encodable.MustNew(reflect.TypeOf(deltaShared{}), nil)
+
+	shared := &deltaInner{A: 1}
+	old := deltaShared{M: map[string]*deltaInner{"a": {A: 2}, "b": {A: 3}}}
+	to := deltaShared{M: map[string]*deltaInner{"a": shared, "b": old.M["b"]}, P: shared}
+
+	buff := new(bytes.Buffer)
+	if err := encodable.EncodeDelta(enc, unsafe.Pointer(&old), unsafe.Pointer(&to), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded deltaShared
+	if err := encodable.Clone(enc, unsafe.Pointer(&decoded), unsafe.Pointer(&old)); err != nil {
+		t.Fatal(err)
+	}
+	if err := encodable.DecodeDelta(enc, unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	if diffs := encodable.Diff(enc, unsafe.Pointer(&to), unsafe.Pointer(&decoded)); diffs != nil {
+		t.Errorf("decoded value differs: %v", diffs)
+	}
+	if decoded.P != decoded.M["a"] {
+		t.Errorf("P and M[a] decoded as different pointers")
+	}
+}
+
+func TestDeltaMalformed(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		value   interface{}
+		encoded []byte
+	}{
+		// changed, then a length of 1<<31, which overflows a 32 bit int.
+		{"Slice length", []int8{}, []byte{0x01, 0xfb, 0x00, 0x00, 0x00, 0x80}},
+		{"Map removed", map[int8]int8{}, []byte{0x01, 0xfb, 0x00, 0x00, 0x00, 0x80}},
+		{"Map updated", map[int8]int8{}, []byte{0x01, 0x00, 0xfb, 0x00, 0x00, 0x00, 0x80}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ty := reflect.TypeOf(tC.value)
+			enc := encodable.MustNew(ty, nil)
+
+			err := encodable.DecodeDelta(enc, unsafe.Pointer(reflect.New(ty).Pointer()), bytes.NewBuffer(tC.encoded))
+			if !errors.Is(err, encio.ErrMalformed) {
+				t.Errorf("got error %v, want %v", err, encio.ErrMalformed)
+			}
+		})
+	}
+}
@@ -10,8 +10,14 @@
 // Encodables typically contain static buffers, used by calls to Encode and Decode. Concurrent usage will certainly fail.
 // If a concurrent-safe Encodable is needed, NewConcurrent is a drop-in, concurrent safe replacement.
 //
+// The encoded form of every Encodable except Memory is independent of the host's byte order and word size.
+// Multi-byte values are written little-endian, bools are written as a single 0 or 1 byte,
+// and int, uint and uintptr are written as variable-length 64bit integers regardless of their size on the host.
+// Decoding an int, uint or uintptr that doesn't fit in the host's int size returns an encio.ErrOverflow error instead of truncating it.
+//
 // NewEncodable creates an Encodable for a type.
 // It takes a *Config, which is used to configure the Encodable.
+// For[T] is a type-safe front-end to New, returning a TypedEncodable which takes *T instead of unsafe.Pointer.
 //
 //
 // Type is an encoder/decoder for type information; an encoder for reflect.Type.
@@ -73,8 +79,71 @@ type Encodable interface {
 // New returns a new Encodable for encoding the type t.
 // config contains settings and information for the generation of the Encodable.
 // In many cases, it can be nil for sane defaults, however some Enodable types require information from the config.
-func New(t reflect.Type, config *Config) Encodable {
-	return newEncodable(t, config.genState())
+// It returns an encio.ErrBadType error if t holds types that can't be encoded with config; see Config.ChanPolicy.
+func New(t reflect.Type, config *Config) (Encodable, error) {
+	if err := checkType(t, config); err != nil {
+		return nil, err
+	}
+	state := config.genState(t)
+	return state.root(newEncodable(t, state)), nil
+}
+
+// checkType returns an encio.ErrBadType error if t, or a type it holds, can't be encoded with config.
+// Types held in interfaces are not known until they are encoded, and are checked then.
+func checkType(t reflect.Type, config *Config) error {
+	if config == nil {
+		config = new(Config)
+	}
+	return checkTypeVisiting(t, t, config, make(map[reflect.Type]bool))
+}
+
+func checkTypeVisiting(root, t reflect.Type, config *Config, visiting map[reflect.Type]bool) error {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+
+	ptrt := reflect.PtrTo(t)
+	if ptrt.Implements(binaryMarshalerIface) && ptrt.Implements(binaryUnmarshalerIface) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		return checkTypeVisiting(root, t.Elem(), config, visiting)
+	case reflect.Map:
+		if err := checkTypeVisiting(root, t.Key(), config, visiting); err != nil {
+			return err
+		}
+		return checkTypeVisiting(root, t.Elem(), config, visiting)
+	case reflect.Struct:
+		for _, f := range structFields(t, config) {
+			if bits := parseTag(f).bits; bits != 0 && !canPackBits(f.Type, bits) {
+				return encio.NewError(encio.ErrBadType, fmt.Sprintf("field %v of %v has tag %v, but %v can't be packed into it", f.Name, t, f.Tag, f.Type), 0)
+			}
+			if err := checkTypeVisiting(root, f.Type, config, visiting); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if config.policy(t.Kind()) == PolicyError {
+			return encio.NewError(encio.ErrBadType, fmt.Sprintf("%v holds %v, which can't be encoded; set a KindPolicy for it in Config", root, t), 0)
+		}
+		return nil
+	case reflect.Interface:
+		if config.Resolver == nil {
+			return encio.NewError(encio.ErrBadConfig, fmt.Sprintf("%v holds %v, which needs a Resolver (config.Resolver is nil)", root, t), 0)
+		}
+		return nil
+	case reflect.Bool, reflect.String,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return nil
+	}
+
+	return encio.NewError(encio.ErrBadType, fmt.Sprintf("cannot create encodable for type %v", t), 0)
 }
 
 // newEncodable creates a new Encodable from state.
@@ -141,13 +210,17 @@ func newEncodable(t reflect.Type, state *state) Encodable {
 		return NewBool()
 	case kind == reflect.String:
 		return NewString()
+
+	// Kinds with a KindPolicy other than PolicyError; checkType has made sure.
+	case kind == reflect.Chan, kind == reflect.Func, kind == reflect.UnsafePointer:
+		return NewNil(t)
 	}
 
 	panic(encio.NewError(encio.ErrBadType, fmt.Sprintf("cannot create encodable for type %v", t), 0))
 }
 
 // NewSource returns a Source with the given config and new function.
-func NewSource(config *Config, new func(reflect.Type, *Config) Encodable) *Source {
+func NewSource(config *Config, new func(reflect.Type, *Config) (Encodable, error)) *Source {
 	// we must hold config, so we copy it
 	config = config.copy()
 
@@ -162,16 +235,20 @@ func NewSource(config *Config, new func(reflect.Type, *Config) Encodable) *Sourc
 type Source struct {
 	encs   map[reflect.Type]Encodable
 	config *Config
-	new    func(reflect.Type, *Config) Encodable
+	new    func(reflect.Type, *Config) (Encodable, error)
 }
 
 // GetEncodable returns an encodable for the given type, as created by the new function passed to NewSource.
-func (s *Source) GetEncodable(ty reflect.Type) Encodable {
+// Errors from the new function are returned, and not cached.
+func (s *Source) GetEncodable(ty reflect.Type) (Encodable, error) {
 	if enc, ok := s.encs[ty]; ok {
-		return enc
+		return enc, nil
 	}
 
-	enc := s.new(ty, s.config)
+	enc, err := s.new(ty, s.config)
+	if err != nil {
+		return nil, err
+	}
 	s.encs[ty] = enc
-	return enc
+	return enc, nil
 }
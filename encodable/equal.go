@@ -0,0 +1,468 @@
+package encodable
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Equal reports whether the values at a and b are equal as far as enc is concerned;
+// that is, whether decoding the encoded form of a would give the same result as decoding the encoded form of b.
+//
+// It walks the same tree of Encodables that Encode does, so struct fields that are not encoded are not compared,
+// nil and empty slices are equal, and pointers are compared by their reference structure as well as their values;
+// two pointers to the same value are not equal to two pointers to different, but equal, values.
+// With Config.Aliasing, slices and maps are compared by their reference structure too, and nil slices and maps only equal nil.
+//
+// a and b must be pointers to values of enc's type.
+func Equal(enc Encodable, a, b unsafe.Pointer) bool {
+	checkPtr(a)
+	checkPtr(b)
+
+	d := &differ{
+		first: true,
+	}
+	d.diff(enc, a, b, "")
+	return len(d.diffs) == 0
+}
+
+// Diff returns the differences between the values at a and b, as defined by Equal.
+// It returns nil if the values are equal.
+//
+// a and b must be pointers to values of enc's type.
+func Diff(enc Encodable, a, b unsafe.Pointer) []Difference {
+	checkPtr(a)
+	checkPtr(b)
+
+	d := new(differ)
+	d.diff(enc, a, b, "")
+	return d.diffs
+}
+
+// Difference is a single difference found by Diff.
+type Difference struct {
+	// Path is the location of the difference in Go syntax, relative to the compared values.
+	// i.e. .Likes[1] or .Friends["John"].(time.Time).
+	// Pointers are dereferenced implicitly, like field selectors in Go.
+	Path string
+
+	// Reason describes the difference.
+	Reason string
+}
+
+// String implements fmt.Stringer
+func (d Difference) String() string {
+	if d.Path == "" {
+		return d.Reason
+	}
+	return d.Path + ": " + d.Reason
+}
+
+// differ walks Encodable trees, recording differences between two values.
+type differ struct {
+	// first stops the walk after the first difference.
+	first bool
+	diffs []Difference
+
+	// seenA and seenB record the order pointers were first seen in, mirroring referencer.
+	seenA, seenB map[referenceKey]int
+
+	// arraysA and arraysB record the backing arrays of aliased slices, by their last element, mirroring referencer.
+	arraysA, arraysB map[unsafe.Pointer]seenArray
+
+	keyBuff bytes.Buffer
+}
+
+// seenArray is a backing array found by differ.
+type seenArray struct {
+	start unsafe.Pointer
+	index int
+}
+
+func (d *differ) report(path, format string, args ...interface{}) {
+	d.diffs = append(d.diffs, Difference{
+		Path:   path,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// done returns true if the walk should stop.
+func (d *differ) done() bool {
+	return d.first && len(d.diffs) > 0
+}
+
+func (d *differ) diff(enc Encodable, a, b unsafe.Pointer, path string) {
+	switch e := enc.(type) {
+	case *referencer:
+		d.diff(e.enc, a, b, path)
+
+	case *Concurrent:
+		c := e.get()
+		d.diff(c, a, b, path)
+		e.put(c)
+
+	case *Pointer:
+		d.reference(e.elem, *(*unsafe.Pointer)(a), *(*unsafe.Pointer)(b), path, e.r != nil)
+
+	case *Interface:
+		d.iface(e, a, b, path)
+
+	case *Nil:
+		// nothing is encoded, so they always decode the same.
+
+	case *Struct:
+		for _, m := range e.members {
+			d.diff(
+				m.Encodable,
+				unsafe.Pointer(uintptr(a)+m.offset),
+				unsafe.Pointer(uintptr(b)+m.offset),
+				path+"."+m.name,
+			)
+			if d.done() {
+				return
+			}
+		}
+
+	case *Array:
+		esize := e.elem.Type().Size()
+		for i := uintptr(0); i < e.len; i++ {
+			d.diff(
+				e.elem,
+				unsafe.Pointer(uintptr(a)+i*esize),
+				unsafe.Pointer(uintptr(b)+i*esize),
+				fmt.Sprintf("%v[%v]", path, i),
+			)
+			if d.done() {
+				return
+			}
+		}
+
+	case *Slice:
+		if e.r != nil {
+			d.aliasedSlice(e, a, b, path)
+			return
+		}
+
+		va, vb := reflect.NewAt(e.t, a).Elem(), reflect.NewAt(e.t, b).Elem()
+		if va.Len() != vb.Len() {
+			d.report(path, "length %v != %v", va.Len(), vb.Len())
+			return
+		}
+		for i := 0; i < va.Len(); i++ {
+			d.diff(
+				e.elem,
+				unsafe.Pointer(va.Index(i).UnsafeAddr()),
+				unsafe.Pointer(vb.Index(i).UnsafeAddr()),
+				fmt.Sprintf("%v[%v]", path, i),
+			)
+			if d.done() {
+				return
+			}
+		}
+
+	case *Map:
+		d.mapping(e, a, b, path)
+
+	case *String:
+		if sa, sb := *(*string)(a), *(*string)(b); sa != sb {
+			d.report(path, "%q != %q", sa, sb)
+		}
+
+	case *Bool, *Uint8, *Uint16, *Uint32, *Uint64, *Uint, *Uintptr,
+		*Int8, *Int16, *Int32, *Int64, *Int,
+		*Float32, *Float64, *Complex64, *Complex128:
+		// These are encoded bit-for-bit, so comparing memory is the same as comparing their encoded form.
+		size := int(enc.Type().Size())
+		if !bytes.Equal(unsafe.Slice((*byte)(a), size), unsafe.Slice((*byte)(b), size)) {
+			d.report(path, "%v != %v", reflect.NewAt(enc.Type(), a).Elem(), reflect.NewAt(enc.Type(), b).Elem())
+		}
+
+	default:
+		// We don't know how enc is structured, so compare the encoded data.
+		ba, bb := new(bytes.Buffer), new(bytes.Buffer)
+		erra, errb := enc.Encode(a, ba), enc.Encode(b, bb)
+		switch {
+		case erra != nil || errb != nil:
+			d.report(path, "cannot compare; encoding errors %v and %v", erra, errb)
+		case !bytes.Equal(ba.Bytes(), bb.Bytes()):
+			if t := enc.Type(); t != nil {
+				d.report(path, "%v != %v", reflect.NewAt(t, a).Elem(), reflect.NewAt(t, b).Elem())
+			} else {
+				d.report(path, "encoded forms differ")
+			}
+		}
+	}
+}
+
+// reference compares the values pointed to by a and b, in the same manner as referencer.
+// If referenced is false, the reference structure is ignored, as it is by Pointers without a referencer.
+func (d *differ) reference(elem Encodable, a, b unsafe.Pointer, path string, referenced bool) {
+	switch {
+	case a == nil && b == nil:
+		return
+	case a == nil:
+		d.report(path, "nil != non-nil")
+		return
+	case b == nil:
+		d.report(path, "non-nil != nil")
+		return
+	}
+
+	if !referenced {
+		d.diff(elem, a, b, path)
+		return
+	}
+
+	if d.seenA == nil {
+		d.seenA = make(map[referenceKey]int)
+		d.seenB = make(map[referenceKey]int)
+	}
+
+	t := elem.Type()
+	ka, kb := referenceKey{ptr: a, t: t}, referenceKey{ptr: b, t: t}
+	ia, seenA := d.seenA[ka]
+	ib, seenB := d.seenB[kb]
+	if seenA || seenB {
+		if !seenA || !seenB || ia != ib {
+			d.report(path, "reference structure differs")
+		}
+		return
+	}
+
+	d.seenA[ka] = len(d.seenA)
+	d.seenB[kb] = len(d.seenB)
+	d.diff(elem, a, b, path)
+}
+
+func (d *differ) iface(e *Interface, a, b unsafe.Pointer, path string) {
+	ia, ib := reflect.NewAt(e.t, a).Elem(), reflect.NewAt(e.t, b).Elem()
+	switch {
+	case ia.IsNil() && ib.IsNil():
+		return
+	case ia.IsNil():
+		d.report(path, "nil != %v", ib.Elem().Type())
+		return
+	case ib.IsNil():
+		d.report(path, "%v != nil", ia.Elem().Type())
+		return
+	}
+
+	ty := ia.Elem().Type()
+	if ty != ib.Elem().Type() {
+		d.report(path, "%v != %v", ty, ib.Elem().Type())
+		return
+	}
+
+	enc, err := e.getEncodable(ty)
+	if err != nil {
+		d.report(path, "cannot compare; %v", err)
+		return
+	}
+
+	// interface contents aren't addressable; compare copies.
+	ca, cb := reflect.New(ty), reflect.New(ty)
+	ca.Elem().Set(ia.Elem())
+	cb.Elem().Set(ib.Elem())
+	d.diff(enc, unsafe.Pointer(ca.Pointer()), unsafe.Pointer(cb.Pointer()), fmt.Sprintf("%v.(%v)", path, ty))
+}
+
+// aliasedSlice compares slices in the same manner as referencer; by their nil-ness, capacity and the backing arrays they share.
+func (d *differ) aliasedSlice(e *Slice, a, b unsafe.Pointer, path string) {
+	sa, sb := (*sliceHeader)(a), (*sliceHeader)(b)
+	switch {
+	case sa.data == nil && sb.data == nil:
+		return
+	case sa.data == nil:
+		d.report(path, "nil != non-nil")
+		return
+	case sb.data == nil:
+		d.report(path, "non-nil != nil")
+		return
+	case sa.len != sb.len:
+		d.report(path, "length %v != %v", sa.len, sb.len)
+		return
+	case sa.cap != sb.cap:
+		d.report(path, "capacity %v != %v", sa.cap, sb.cap)
+		return
+	}
+
+	size := e.t.Elem().Size()
+	if sa.cap == 0 || size == 0 {
+		return
+	}
+
+	if d.arraysA == nil {
+		d.arraysA = make(map[unsafe.Pointer]seenArray)
+		d.arraysB = make(map[unsafe.Pointer]seenArray)
+	}
+
+	lastA := unsafe.Pointer(uintptr(sa.data) + uintptr(sa.cap-1)*size)
+	lastB := unsafe.Pointer(uintptr(sb.data) + uintptr(sb.cap-1)*size)
+	arrayA, seenA := d.arraysA[lastA]
+	arrayB, seenB := d.arraysB[lastB]
+	seenA = seenA && uintptr(sa.data) >= uintptr(arrayA.start)
+	seenB = seenB && uintptr(sb.data) >= uintptr(arrayB.start)
+	if seenA || seenB {
+		if !seenA || !seenB || arrayA.index != arrayB.index ||
+			uintptr(sa.data)-uintptr(arrayA.start) != uintptr(sb.data)-uintptr(arrayB.start) {
+			d.report(path, "reference structure differs")
+		}
+		return
+	}
+
+	d.arraysA[lastA] = seenArray{start: sa.data, index: len(d.arraysA)}
+	d.arraysB[lastB] = seenArray{start: sb.data, index: len(d.arraysB)}
+
+	// the whole backing array is encoded.
+	for i := 0; i < sa.cap; i++ {
+		d.diff(
+			e.elem,
+			unsafe.Pointer(uintptr(sa.data)+uintptr(i)*size),
+			unsafe.Pointer(uintptr(sb.data)+uintptr(i)*size),
+			fmt.Sprintf("%v[%v]", path, i),
+		)
+		if d.done() {
+			return
+		}
+	}
+}
+
+func (d *differ) mapping(e *Map, a, b unsafe.Pointer, path string) {
+	if e.r != nil {
+		ha, hb := *(*unsafe.Pointer)(a), *(*unsafe.Pointer)(b)
+		switch {
+		case ha == nil && hb == nil:
+			return
+		case ha == nil:
+			d.report(path, "nil != non-nil")
+			return
+		case hb == nil:
+			d.report(path, "non-nil != nil")
+			return
+		}
+
+		if d.seenA == nil {
+			d.seenA = make(map[referenceKey]int)
+			d.seenB = make(map[referenceKey]int)
+		}
+
+		ka, kb := referenceKey{ptr: ha, t: e.t}, referenceKey{ptr: hb, t: e.t}
+		ia, seenA := d.seenA[ka]
+		ib, seenB := d.seenB[kb]
+		if seenA || seenB {
+			if !seenA || !seenB || ia != ib {
+				d.report(path, "reference structure differs")
+			}
+			return
+		}
+
+		d.seenA[ka] = len(d.seenA)
+		d.seenB[kb] = len(d.seenB)
+	}
+
+	ma, mb := reflect.NewAt(e.t, a).Elem(), reflect.NewAt(e.t, b).Elem()
+	if ma.Len() != mb.Len() {
+		d.report(path, "length %v != %v", ma.Len(), mb.Len())
+		return
+	}
+
+	// keys are matched by their encoded form, as they are when decoded, rather than with ==;
+	// NaN keys can't be looked up with MapIndex, and keys such as -0 and +0 are == but encode differently.
+	keysA, inA, err := d.groupEntries(e, ma)
+	if err != nil {
+		d.report(path, "cannot compare; encoding error %v", err)
+		return
+	}
+	_, inB, err := d.groupEntries(e, mb)
+	if err != nil {
+		d.report(path, "cannot compare; encoding error %v", err)
+		return
+	}
+
+	// map values aren't addressable; compare copies.
+	va, vb := reflect.New(e.t.Elem()), reflect.New(e.t.Elem())
+	for _, key := range keysA {
+		as, bs := inA[key], inB[key]
+
+		// keys that encode the same, such as NaNs, are paired with an equal value where there is one,
+		// and then with the remaining values in order.
+		paired := make([]bool, len(bs))
+		pairs := make([]int, len(as))
+		for i := range as {
+			pairs[i] = -1
+			va.Elem().Set(as[i].val)
+			for j := range bs {
+				if paired[j] {
+					continue
+				}
+				vb.Elem().Set(bs[j].val)
+				if Equal(e.val, unsafe.Pointer(va.Pointer()), unsafe.Pointer(vb.Pointer())) {
+					pairs[i], paired[j] = j, true
+					break
+				}
+			}
+		}
+		next := 0
+		for i := range as {
+			for pairs[i] < 0 && next < len(bs) {
+				if !paired[next] {
+					pairs[i], paired[next] = next, true
+				}
+				next++
+			}
+		}
+
+		for i := range as {
+			kpath := fmt.Sprintf("%v[%#v]", path, as[i].key)
+			if pairs[i] < 0 {
+				d.report(kpath, "missing in second value")
+			} else {
+				va.Elem().Set(as[i].val)
+				vb.Elem().Set(bs[pairs[i]].val)
+				d.diff(e.val, unsafe.Pointer(va.Pointer()), unsafe.Pointer(vb.Pointer()), kpath)
+			}
+			if d.done() {
+				return
+			}
+		}
+	}
+
+	// lengths are equal, so a key missing from a means there is also one missing from b, which has already been reported.
+}
+
+// groupEntries returns the entries of the map m grouped by their encoded key, and the encoded keys in the order they were found.
+func (d *differ) groupEntries(e *Map, m reflect.Value) ([]string, map[string][]mapEntry, error) {
+	var keys []string
+	entries := make(map[string][]mapEntry, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		key, err := d.encodeKey(e, iter.Key())
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := entries[key]; !ok {
+			keys = append(keys, key)
+		}
+		entries[key] = append(entries[key], mapEntry{key: iter.Key(), val: iter.Value()})
+	}
+	return keys, entries, nil
+}
+
+// encodeKey returns the encoded form of the map key k.
+// References made encoding it are forgotten, as they are when sorting keys for Config.Canonical.
+func (d *differ) encodeKey(e *Map, k reflect.Value) (string, error) {
+	key := reflect.New(e.t.Key())
+	key.Elem().Set(k)
+
+	var mark int
+	if e.state.r != nil {
+		mark = e.state.r.mark()
+	}
+	d.keyBuff.Reset()
+	err := e.key.Encode(unsafe.Pointer(key.Pointer()), &d.keyBuff)
+	if e.state.r != nil {
+		e.state.r.truncate(mark)
+	}
+	return d.keyBuff.String(), err
+}
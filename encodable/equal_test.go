@@ -0,0 +1,175 @@
+package encodable_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encodable"
+)
+
+type equalStruct struct {
+	Name     string
+	Likes    []string
+	Friends  map[string]int
+	Best     *equalStruct
+	Worst    *equalStruct
+	Anything interface{}
+	private  int
+}
+
+func TestEqual(t *testing.T) {
+	shared := &equalStruct{Name: "shared"}
+
+	testCases := []struct {
+		desc   string
+		config *encodable.Config
+		a, b   equalStruct
+		want   []string
+	}{
+		{
+			desc: "Equal",
+			a:    equalStruct{Name: "John", Likes: []string{"Computers", "Music"}, Friends: map[string]int{"Jane": 1}},
+			b:    equalStruct{Name: "John", Likes: []string{"Computers", "Music"}, Friends: map[string]int{"Jane": 1}},
+		},
+		{
+			desc: "Different members",
+			a:    equalStruct{Name: "John", Likes: []string{"Computers", "Music"}},
+			b:    equalStruct{Name: "Jane", Likes: []string{"Computers", "Art"}},
+			want: []string{".Likes[1]", ".Name"},
+		},
+		{
+			desc: "Nil and empty slices",
+			a:    equalStruct{Likes: nil},
+			b:    equalStruct{Likes: []string{}},
+		},
+		{
+			desc: "Unexported ignored",
+			a:    equalStruct{private: 1},
+			b:    equalStruct{private: 2},
+		},
+		{
+			desc:   "Unexported included",
+			config: &encodable.Config{IncludeUnexported: true},
+			a:      equalStruct{private: 1},
+			b:      equalStruct{private: 2},
+			want:   []string{".private"},
+		},
+		{
+			desc: "Map values",
+			a:    equalStruct{Friends: map[string]int{"Jane": 1, "Joe": 2}},
+			b:    equalStruct{Friends: map[string]int{"Jane": 1, "Joe": 3}},
+			want: []string{`.Friends["Joe"]`},
+		},
+		{
+			desc: "Map keys",
+			a:    equalStruct{Friends: map[string]int{"Jane": 1}},
+			b:    equalStruct{Friends: map[string]int{"Joe": 1}},
+			want: []string{`.Friends["Jane"]`},
+		},
+		{
+			desc: "Equal references",
+			a:    equalStruct{Best: shared, Worst: shared},
+			b:    equalStruct{Best: &equalStruct{Name: "shared"}, Worst: &equalStruct{Name: "shared"}},
+			want: []string{".Worst"},
+		},
+		{
+			desc: "Nil pointer",
+			a:    equalStruct{Best: shared},
+			b:    equalStruct{},
+			want: []string{".Best"},
+		},
+		{
+			desc: "Interface types",
+			a:    equalStruct{Anything: int(1)},
+			b:    equalStruct{Anything: uint(1)},
+			want: []string{".Anything"},
+		},
+		{
+			desc: "Interface values",
+			a:    equalStruct{Anything: []int{1, 2}},
+			b:    equalStruct{Anything: []int{1, 3}},
+			want: []string{".Anything.([]int)[1]"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := &encodable.Config{Resolver: encodable.NewRegisterResolver(nil)}
+			if tC.config != nil {
+				config.IncludeUnexported = tC.config.IncludeUnexported
+			}
+			enc := encodable.MustNew(reflect.TypeOf(equalStruct{}), config)
+
+			diffs := encodable.Diff(enc, unsafe.Pointer(&tC.a), unsafe.Pointer(&tC.b))
+			var got []string
+			for _, d := range diffs {
+				got = append(got, d.Path)
+			}
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got differences %v, want differences at %v", diffs, tC.want)
+			}
+
+			if equal := encodable.Equal(enc, unsafe.Pointer(&tC.a), unsafe.Pointer(&tC.b)); equal != (len(tC.want) == 0) {
+				t.Errorf("Equal returned %v, but differences are %v", equal, diffs)
+			}
+		})
+	}
+}
+
+func TestEqualCyclic(t *testing.T) {
+	a := &equalStruct{Name: "a"}
+	a.Best = a
+	b := &equalStruct{Name: "a"}
+	b.Best = b
+	c := &equalStruct{Name: "a"}
+	c.Best = &equalStruct{Name: "a"}
+
+	enc := encodable.MustNew(reflect.TypeOf(a), &encodable.Config{Resolver: encodable.NewRegisterResolver(nil)})
+
+	if !encodable.Equal(enc, unsafe.Pointer(&a), unsafe.Pointer(&b)) {
+		t.Errorf("cyclic values are not equal; %v", encodable.Diff(enc, unsafe.Pointer(&a), unsafe.Pointer(&b)))
+	}
+
+	if encodable.Equal(enc, unsafe.Pointer(&a), unsafe.Pointer(&c)) {
+		t.Errorf("cyclic value is equal to non-cyclic value")
+	}
+}
+
+func TestEqualFloatKeys(t *testing.T) {
+	nans := func(vals ...string) map[float64]string {
+		m := make(map[float64]string)
+		for _, v := range vals {
+			m[math.NaN()] = v
+		}
+		return m
+	}
+
+	testCases := []struct {
+		desc string
+		a, b map[float64]string
+		want []string
+	}{
+		{"NaN keys", nans("a", "b", "c"), nans("c", "a", "b"), nil},
+		{"NaN values", nans("a", "b"), nans("a", "c"), []string{"[NaN]"}},
+		{"Signed zeros", map[float64]string{0: "a"}, map[float64]string{math.Copysign(0, -1): "a"}, []string{"[0]"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			enc := encodable.MustNew(reflect.TypeOf(tC.a), nil)
+
+			if !encodable.Equal(enc, unsafe.Pointer(&tC.a), unsafe.Pointer(&tC.a)) {
+				t.Errorf("value isn't equal to itself; %v", encodable.Diff(enc, unsafe.Pointer(&tC.a), unsafe.Pointer(&tC.a)))
+			}
+
+			diffs := encodable.Diff(enc, unsafe.Pointer(&tC.a), unsafe.Pointer(&tC.b))
+			var got []string
+			for _, d := range diffs {
+				got = append(got, d.Path)
+			}
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got differences %v, want differences at %v", diffs, tC.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package encodable
+
+import "reflect"
+
+// SetIntSize sets the size in bits of int, uint and uintptr used when decoding,
+// emulating a platform with a different word size. It returns a function restoring the original sizes.
+func SetIntSize(bits int) (restore func()) {
+	oldInt, oldUintptr := intSize, uintptrSize
+	intSize, uintptrSize = bits, bits
+	return func() {
+		intSize, uintptrSize = oldInt, oldUintptr
+	}
+}
+
+// CanReference exposes canReference, the check for whether Encodables for t need a referencer.
+func CanReference(t reflect.Type, config *Config) bool {
+	if config == nil {
+		config = new(Config)
+	}
+	return canReference(t, config)
+}
+
+// MustNew is New, panicking on error.
+func MustNew(t reflect.Type, config *Config) Encodable {
+	enc, err := New(t, config)
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
@@ -1,8 +1,10 @@
 package encodable
 
 import (
+	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"reflect"
 	"unsafe"
 
@@ -228,6 +230,31 @@ const (
 	maxSingleUint = 255 - 8
 )
 
+// intSize and uintptrSize are the sizes in bits of int, uint and uintptr on this platform.
+// They are variables so tests can emulate other platforms.
+var (
+	intSize     = bits.UintSize
+	uintptrSize = int(unsafe.Sizeof(uintptr(0))) * 8
+)
+
+// decodeUvarint reads the size bytes of a variable length unsigned integer into buff and returns it,
+// returning an encio.ErrOverflow error if it doesn't fit in width bits.
+func decodeUvarint(buff []byte, size uint8, width int, r io.Reader) (uint64, error) {
+	if err := encio.Read(buff[:size], r); err != nil {
+		return 0, err
+	}
+
+	var i uint64
+	for j := uint8(0); j < size; j++ {
+		i |= uint64(buff[j]) << (j * 8)
+	}
+
+	if width < 64 && i>>width != 0 {
+		return 0, encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit integer", i, width), 1)
+	}
+	return i, nil
+}
+
 // String implements Encodable
 func (e *Uint) String() string {
 	return "Uint"
@@ -269,22 +296,17 @@ func (e *Uint) Decode(ptr unsafe.Pointer, r io.Reader) error {
 		return err
 	}
 
-	i := (*uint)(ptr)
-
-	if e.buff[0] > maxSingleUint {
-		size := e.buff[0] - maxSingleUint
-		if err := encio.Read(e.buff[:size], r); err != nil {
-			return err
-		}
+	if e.buff[0] <= maxSingleUint {
+		*(*uint)(ptr) = uint(e.buff[0])
+		return nil
+	}
 
-		*i = 0
-		for j := byte(0); j < size; j++ {
-			*i |= uint(e.buff[j]) << (j * 8)
-		}
-	} else {
-		*i = uint(e.buff[0])
+	i, err := decodeUvarint(e.buff[:], e.buff[0]-maxSingleUint, intSize, r)
+	if err != nil {
+		return err
 	}
 
+	*(*uint)(ptr) = uint(i)
 	return nil
 }
 
@@ -519,20 +541,20 @@ func (e *Int) Type() reflect.Type {
 // Encode implements Encodable
 func (e *Int) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
-	i := *(*int)(ptr)
+	i := int64(*(*int)(ptr))
 	size := 1
-	if i <= math.MaxInt8 && i >= int(minSingleInt) {
+	if i <= math.MaxInt8 && i >= int64(minSingleInt) {
 		e.buff[0] = uint8(i)
 	} else {
-		end := int(0)
-		if i < 0 {
-			end = -1
-		}
-
-		for i != end {
+		// write bytes until only sign bits remain, and the top bit of the last byte written is the sign.
+		for {
+			last := int8(i)
 			e.buff[size] = uint8(i)
 			i >>= 8
 			size++
+			if (i == 0 && last >= 0) || (i == -1 && last < 0) {
+				break
+			}
 		}
 
 		e.buff[0] = uint8((-1 << 7) + size - 1)
@@ -560,12 +582,22 @@ func (e *Int) Decode(ptr unsafe.Pointer, r io.Reader) error {
 		return err
 	}
 
-	i := (*int)(ptr)
-	*i = 0
+	var i int64
 	for j := 0; j < size; j++ {
-		*i |= int(e.buff[j]) << (j * 8)
+		i |= int64(e.buff[j]) << (j * 8)
+	}
+
+	if size > 0 && size < 8 {
+		// sign extend
+		shift := 64 - size*8
+		i = i << shift >> shift
+	}
+
+	if size*8 > intSize && (i < -1<<(intSize-1) || i > 1<<(intSize-1)-1) {
+		return encio.NewError(encio.ErrOverflow, fmt.Sprintf("%v does not fit in a %v bit int", i, intSize), 0)
 	}
 
+	*(*int)(ptr) = int(i)
 	return nil
 }
 
@@ -620,21 +652,16 @@ func (e *Uintptr) Decode(ptr unsafe.Pointer, r io.Reader) error {
 		return err
 	}
 
-	i := (*uintptr)(ptr)
-
-	if e.buff[0] > maxSingleUint {
-		size := e.buff[0] - maxSingleUint
-		if err := encio.Read(e.buff[:size], r); err != nil {
-			return err
-		}
+	if e.buff[0] <= maxSingleUint {
+		*(*uintptr)(ptr) = uintptr(e.buff[0])
+		return nil
+	}
 
-		*i = 0
-		for j := byte(0); j < size; j++ {
-			*i |= uintptr(e.buff[j]) << (j * 8)
-		}
-	} else {
-		*i = uintptr(e.buff[0])
+	i, err := decodeUvarint(e.buff[:], e.buff[0]-maxSingleUint, uintptrSize, r)
+	if err != nil {
+		return err
 	}
 
+	*(*uintptr)(ptr) = uintptr(i)
 	return nil
 }
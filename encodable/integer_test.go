@@ -3,6 +3,7 @@ package encodable_test
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"math/bits"
 	"testing"
 	"unsafe"
@@ -297,7 +298,15 @@ func TestInt64(t *testing.T) {
 
 func TestInt(t *testing.T) {
 	testCases := []int{
-		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, 1<<32 - 1, 1<<(bits.UintSize-1) - 1, -1, -1 << 63,
+		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, math.MaxInt, -1, math.MinInt,
+		127, 128, 200, -119, -120, -129, -200, -65280, math.MaxInt32, math.MinInt32,
+	}
+	if bits.UintSize == 64 {
+		// typed, so the package builds on 32 bit hosts.
+		wide := []int64{1<<32 - 1, 1 << 31, -1<<31 - 1}
+		for _, i := range wide {
+			testCases = append(testCases, int(i))
+		}
 	}
 
 	e := encodable.NewInt()
@@ -374,7 +383,7 @@ func BenchmarkUint(b *testing.B) {
 
 func BenchmarkInt(b *testing.B) {
 	ints := []int{
-		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, 1<<32 - 1, 1<<(bits.UintSize-1) - 1, -1, -1 << 63,
+		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, math.MaxInt32, math.MaxInt, -1, math.MinInt,
 	}
 
 	enc := encodable.NewInt()
@@ -395,7 +404,7 @@ func BenchmarkInt(b *testing.B) {
 
 func TestUintptr(t *testing.T) {
 	testCases := []uintptr{
-		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, 1<<32 - 1, 1<<64 - 1,
+		0, 1, 2, 3, 4, 5, 6, 254, 255, 256, math.MaxUint32, ^uintptr(0),
 	}
 
 	enc := encodable.NewUintptr()
@@ -11,10 +11,7 @@ import (
 // NewMemory returns a new Memory encoder
 func NewMemory(size int) *Memory {
 	return &Memory{
-		buff: reflect.SliceHeader{
-			Len: size,
-			Cap: size,
-		},
+		size: size,
 	}
 }
 
@@ -22,8 +19,10 @@ func NewMemory(size int) *Memory {
 // Initialised with NewMemory(size), it reads/writes directly to the memory at the given address with no internal buffering.
 // Extreme care must be taken, errors from Memory can be difficult to read, let alone helpful in debugging, and are often in the form of panics,
 // or worse still, the silent destruction of the universe.
+//
+// Memory is not portable; the encoded data is the host's in-memory representation, and depends on its byte order and type sizes.
 type Memory struct {
-	buff reflect.SliceHeader
+	size int
 }
 
 // String implements Encodable
@@ -38,19 +37,17 @@ func (e *Memory) Type() reflect.Type {
 
 // Size implemenets Encodable
 func (e *Memory) Size() int {
-	return e.buff.Cap
+	return e.size
 }
 
 // Encode implements Encodable
 func (e *Memory) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
-	e.buff.Data = (uintptr)(ptr)
-	return encio.Write(*(*[]byte)(unsafe.Pointer(&e.buff)), w)
+	return encio.Write(unsafe.Slice((*byte)(ptr), e.size), w)
 }
 
 // Decode implements Decodable
 func (e *Memory) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	checkPtr(ptr)
-	e.buff.Data = (uintptr)(ptr)
-	return encio.Read(*(*[]byte)(unsafe.Pointer(&e.buff)), r)
+	return encio.Read(unsafe.Slice((*byte)(ptr), e.size), r)
 }
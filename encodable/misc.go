@@ -86,7 +86,8 @@ func NewBool() Encodable {
 	}
 }
 
-// Bool is an Encodable for bools
+// Bool is an Encodable for bools.
+// It writes a single byte; 1 for true and 0 for false. Decoding any other value returns encio.ErrMalformed.
 type Bool struct {
 	buff []byte
 }
@@ -109,7 +110,11 @@ func (e *Bool) Type() reflect.Type {
 // Encode implements Encodable
 func (e *Bool) Encode(ptr unsafe.Pointer, w io.Writer) error {
 	checkPtr(ptr)
-	e.buff[0] = *(*byte)(ptr)
+	if *(*bool)(ptr) {
+		e.buff[0] = 1
+	} else {
+		e.buff[0] = 0
+	}
 	return encio.Write(e.buff, w)
 }
 
@@ -119,7 +124,58 @@ func (e *Bool) Decode(ptr unsafe.Pointer, r io.Reader) error {
 	if err := encio.Read(e.buff, r); err != nil {
 		return err
 	}
-	*(*byte)(ptr) = e.buff[0]
+	switch e.buff[0] {
+	case 0:
+		*(*bool)(ptr) = false
+	case 1:
+		*(*bool)(ptr) = true
+	default:
+		return encio.IOError{
+			Err:     encio.ErrMalformed,
+			Message: fmt.Sprintf("bool must be 0 or 1, got %v", e.buff[0]),
+		}
+	}
+	return nil
+}
+
+// NewNil returns a new Nil Encodable for the type t.
+func NewNil(t reflect.Type) *Nil {
+	return &Nil{
+		t: t,
+	}
+}
+
+// Nil is an Encodable for values that aren't encoded, such as channels and funcs with PolicyNil.
+// It writes nothing, and decodes values as their zero value.
+type Nil struct {
+	t reflect.Type
+}
+
+// String implements Encodable
+func (e *Nil) String() string {
+	return fmt.Sprintf("Nil(%v)", e.t)
+}
+
+// Size implements Encodable
+func (e *Nil) Size() int {
+	return 0
+}
+
+// Type implements Encodable
+func (e *Nil) Type() reflect.Type {
+	return e.t
+}
+
+// Encode implements Encodable
+func (e *Nil) Encode(ptr unsafe.Pointer, w io.Writer) error {
+	checkPtr(ptr)
+	return nil
+}
+
+// Decode implements Encodable
+func (e *Nil) Decode(ptr unsafe.Pointer, r io.Reader) error {
+	checkPtr(ptr)
+	reflect.NewAt(e.t, ptr).Elem().Set(reflect.Zero(e.t))
 	return nil
 }
 
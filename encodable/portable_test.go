@@ -0,0 +1,177 @@
+package encodable_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+// TestByteOrder checks that fixed size values are written little-endian no matter the host's byte order.
+func TestByteOrder(t *testing.T) {
+	var (
+		u16  = uint16(0x0102)
+		u32  = uint32(0x01020304)
+		u64  = uint64(0x0102030405060708)
+		i16  = int16(-0x0102)
+		i32  = int32(-0x01020304)
+		i64  = int64(-0x0102030405060708)
+		f32  = float32(1.2345)
+		f64  = float64(1.2345678)
+		c64  = complex64(1.2345 + 2.3456i)
+		c128 = complex128(1.2345678 + 2.3456789i)
+	)
+
+	le := binary.LittleEndian
+	testCases := []struct {
+		enc  encodable.Encodable
+		ptr  unsafe.Pointer
+		want []byte
+	}{
+		{encodable.NewUint16(), unsafe.Pointer(&u16), le.AppendUint16(nil, u16)},
+		{encodable.NewUint32(), unsafe.Pointer(&u32), le.AppendUint32(nil, u32)},
+		{encodable.NewUint64(), unsafe.Pointer(&u64), le.AppendUint64(nil, u64)},
+		{encodable.NewInt16(), unsafe.Pointer(&i16), le.AppendUint16(nil, uint16(i16))},
+		{encodable.NewInt32(), unsafe.Pointer(&i32), le.AppendUint32(nil, uint32(i32))},
+		{encodable.NewInt64(), unsafe.Pointer(&i64), le.AppendUint64(nil, uint64(i64))},
+		{encodable.NewFloat32(), unsafe.Pointer(&f32), le.AppendUint32(nil, math.Float32bits(f32))},
+		{encodable.NewFloat64(), unsafe.Pointer(&f64), le.AppendUint64(nil, math.Float64bits(f64))},
+		{encodable.NewComplex64(), unsafe.Pointer(&c64), le.AppendUint32(le.AppendUint32(nil, math.Float32bits(real(c64))), math.Float32bits(imag(c64)))},
+		{encodable.NewComplex128(), unsafe.Pointer(&c128), le.AppendUint64(le.AppendUint64(nil, math.Float64bits(real(c128))), math.Float64bits(imag(c128)))},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.enc.String(), func(t *testing.T) {
+			buff := new(bytes.Buffer)
+			if err := tC.enc.Encode(tC.ptr, buff); err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+			if !bytes.Equal(buff.Bytes(), tC.want) {
+				t.Fatalf("wrote %v, want %v", buff.Bytes(), tC.want)
+			}
+		})
+	}
+}
+
+// TestByteOrderDecode checks that fixed size values are read little-endian no matter the host's byte order.
+func TestByteOrderDecode(t *testing.T) {
+	var (
+		u16 uint16
+		u32 uint32
+		u64 uint64
+		i16 int16
+		i32 int32
+		i64 int64
+		f32 float32
+		f64 float64
+	)
+
+	testCases := []struct {
+		enc  encodable.Encodable
+		ptr  unsafe.Pointer
+		data []byte
+		got  func() interface{}
+		want interface{}
+	}{
+		{encodable.NewUint16(), unsafe.Pointer(&u16), []byte{0x02, 0x01}, func() interface{} { return u16 }, uint16(0x0102)},
+		{encodable.NewUint32(), unsafe.Pointer(&u32), []byte{0x04, 0x03, 0x02, 0x01}, func() interface{} { return u32 }, uint32(0x01020304)},
+		{encodable.NewUint64(), unsafe.Pointer(&u64), []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}, func() interface{} { return u64 }, uint64(0x0102030405060708)},
+		{encodable.NewInt16(), unsafe.Pointer(&i16), []byte{0xfe, 0xfe}, func() interface{} { return i16 }, int16(-0x0102)},
+		{encodable.NewInt32(), unsafe.Pointer(&i32), []byte{0xfc, 0xfc, 0xfd, 0xfe}, func() interface{} { return i32 }, int32(-0x01020304)},
+		{encodable.NewInt64(), unsafe.Pointer(&i64), []byte{0xf8, 0xf8, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe}, func() interface{} { return i64 }, int64(-0x0102030405060708)},
+		{encodable.NewFloat32(), unsafe.Pointer(&f32), []byte{0x00, 0x00, 0x80, 0x3f}, func() interface{} { return f32 }, float32(1)},
+		{encodable.NewFloat64(), unsafe.Pointer(&f64), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f}, func() interface{} { return f64 }, float64(1)},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.enc.String(), func(t *testing.T) {
+			if err := tC.enc.Decode(tC.ptr, bytes.NewReader(tC.data)); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if got := tC.got(); got != tC.want {
+				t.Fatalf("decoded %v from %v, want %v", got, tC.data, tC.want)
+			}
+		})
+	}
+}
+
+func TestBoolPortable(t *testing.T) {
+	enc := encodable.NewBool()
+
+	var b bool
+	// Not a valid bool, but memory could conceivably hold it.
+	*(*byte)(unsafe.Pointer(&b)) = 2
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(unsafe.Pointer(&b), buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if !bytes.Equal(buff.Bytes(), []byte{1}) {
+		t.Fatalf("true encoded as %v, want [1]", buff.Bytes())
+	}
+
+	err := enc.Decode(unsafe.Pointer(&b), bytes.NewReader([]byte{2}))
+	if !errors.Is(err, encio.ErrMalformed) {
+		t.Fatalf("decoding 2 gave error %v, want %v", err, encio.ErrMalformed)
+	}
+}
+
+// Test32Bit emulates decoding on a 32bit machine.
+// Values are given as their encoded bytes, as they can't all be encoded on a 32bit machine.
+func Test32Bit(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		enc      encodable.Encodable
+		data     []byte
+		want     int64
+		overflow bool
+	}{
+		{"int(MaxInt32)", encodable.NewInt(), []byte{0x84, 0xff, 0xff, 0xff, 0x7f}, math.MaxInt32, false},
+		{"int(MinInt32)", encodable.NewInt(), []byte{0x84, 0x00, 0x00, 0x00, 0x80}, math.MinInt32, false},
+		{"int(MaxInt32+1)", encodable.NewInt(), []byte{0x85, 0x00, 0x00, 0x00, 0x80, 0x00}, 0, true},
+		{"int(MinInt32-1)", encodable.NewInt(), []byte{0x85, 0xff, 0xff, 0xff, 0x7f, 0xff}, 0, true},
+		{"int(1<<40)", encodable.NewInt(), []byte{0x86, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, 0, true},
+		{"uint(MaxUint32)", encodable.NewUint(), []byte{0xfb, 0xff, 0xff, 0xff, 0xff}, math.MaxUint32, false},
+		{"uint(MaxUint32+1)", encodable.NewUint(), []byte{0xfc, 0x00, 0x00, 0x00, 0x00, 0x01}, 0, true},
+		{"uintptr(MaxUint32)", encodable.NewUintptr(), []byte{0xfb, 0xff, 0xff, 0xff, 0xff}, math.MaxUint32, false},
+		{"uintptr(MaxUint32+1)", encodable.NewUintptr(), []byte{0xfc, 0x00, 0x00, 0x00, 0x00, 0x01}, 0, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// decoded is big enough for the host's int, and keeps its value when there is an error.
+			var decoded uint64
+			restore := encodable.SetIntSize(32)
+			err := tC.enc.Decode(unsafe.Pointer(&decoded), bytes.NewReader(tC.data))
+			restore()
+
+			if tC.overflow {
+				if !errors.Is(err, encio.ErrOverflow) {
+					t.Fatalf("got error %v, want %v", err, encio.ErrOverflow)
+				}
+				if decoded != 0 {
+					t.Fatalf("wrote %v on overflow", decoded)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+
+			var got int64
+			switch tC.enc.(type) {
+			case *encodable.Int:
+				got = int64(*(*int)(unsafe.Pointer(&decoded)))
+			case *encodable.Uint:
+				got = int64(*(*uint)(unsafe.Pointer(&decoded)))
+			case *encodable.Uintptr:
+				got = int64(*(*uintptr)(unsafe.Pointer(&decoded)))
+			}
+			if got != tC.want {
+				t.Fatalf("decoded %v, want %v", got, tC.want)
+			}
+		})
+	}
+}
@@ -10,24 +10,85 @@ import (
 )
 
 // referencer resolves recursive types and values, and stops re-encoding of pointers to the same value.
-// member Encodables call newEncodable, encodeReference and decodeReference instead of performing their own sub-type encoding.
+// member Encodables call encodeReference and decodeReference instead of performing their own sub-type encoding,
+// and with Config.Aliasing, Slices and Maps call encodeSlice, decodeSlice, encodeMap and decodeMap.
 type referencer struct {
 	// Encodable we're wrapping
-	// it doesn't need to be top-level, just a member Encodable that will receive calls to Encode() and Decode() before
-	// any calls will be made to encode/decodeReference. Simply wrapping the first Encodable to ask to have a referencer should suffice.
-	enc Encodable
+	// Encodables made by New wrap the top-level Encodable. Others wrap the first Encodable to ask to have a referencer,
+	// which works as long as it will receive calls to Encode() and Decode() before any calls will be made to encode/decodeReference.
+	enc  Encodable
+	buff [1]byte
 
-	// encoders is a map of Encodables for given types. used to prevent infinite recursion when resolving recursive types.
-	encoders map[reflect.Type]*Concurrent
-	buff     [1]byte
+	// referenceTable is reset on every Encode and Decode, unless it belongs to shared.
+	*referenceTable
+	shared *References
 
+	// intEnc is used for encoding the index.
+	intEnc Int
+	// lenEnc is used for encoding the offset, length and capacity of slices.
+	lenEnc encio.Uvarint
+}
+
+// newReferencer returns a new referencer using the table of shared, or its own table if shared is nil.
+func newReferencer(shared *References) *referencer {
+	if shared == nil {
+		return &referencer{
+			referenceTable: new(referenceTable),
+		}
+	}
+	return &referencer{
+		referenceTable: &shared.table,
+		shared:         shared,
+	}
+}
+
+// referenceTable holds the values that have been encoded or decoded.
+type referenceTable struct {
 	// index is a unique id for an encoded reference type. indexes are not static, and are resolved on every decode and encode.
 	// for encoding, this is used to ensure the type at a given pointer is only encoded once, with subsequent encodes only writing a link (index) to the previously encoded value to the buffer.
 	// for decoding, this is used to keep track of decoded types, and to resolve links (index) to previously decoded values when they are read from the buffer.
-	references []unsafe.Pointer
+	references []reference
 
-	// intEnc is used for encoding the index.
-	intEnc Int
+	// index maps the keys of the first indexed entries of references to their index, so large tables can be searched in constant time.
+	// It is only kept up to date by find, and only once references outgrows indexThreshold; small tables are scanned.
+	index   map[referenceKey]int
+	indexed int
+}
+
+// reference is an entry in the reference table; a value, or the backing array of a slice.
+type reference struct {
+	// ptr points to the value, or to the first element of the backing array.
+	ptr unsafe.Pointer
+
+	// t is the type of the value, or the slice type for backing arrays.
+	t reflect.Type
+
+	// cap is the number of elements in a backing array, or 0 for values.
+	cap int
+
+	// shadows is one more than the index of the earlier reference with the same key, which this one replaced in index,
+	// or 0 if there isn't one. It is set when the reference is indexed, and restores the earlier one when it is truncated.
+	shadows int
+}
+
+// referenceKey identifies a reference when encoding.
+type referenceKey struct {
+	ptr   unsafe.Pointer
+	t     reflect.Type
+	array bool
+}
+
+// key returns the key for r. Backing arrays are keyed by their last element,
+// which every slice of the array that extends to the end of it shares.
+func (r reference) key() referenceKey {
+	if r.cap == 0 {
+		return referenceKey{ptr: r.ptr, t: r.t}
+	}
+	return referenceKey{
+		ptr:   unsafe.Pointer(uintptr(r.ptr) + uintptr(r.cap-1)*r.t.Elem().Size()),
+		t:     r.t,
+		array: true,
+	}
 }
 
 const (
@@ -36,43 +97,82 @@ const (
 	refEncoded
 )
 
-// newEncodable returns the concurrent-safe encodable for the type t, creating if needed.
-// that is, a recursive-safe Encodable. Recursive types *must* use this instead of NewEncodable when resolving
-// their element types, else they loop to infinity.
-// also, they must be concurrent safe on the off chance that a type embeds itself, and calls itself from inside Encode and Decode.
-func (ref *referencer) newEncodable(t reflect.Type, state *state) Encodable {
-	if enc, ok := ref.encoders[t]; ok {
-		return enc
+// pointerLimit is the number of pointers at which a type can hold references.
+const pointerLimit = 2
+
+// canReference returns true if values of t can hold two pointers to the same place, or a pointer to itself.
+// An Encodable for a type that can't doesn't need a referencer.
+// It is conservative; interfaces, recursive types, and with Config.Aliasing, slices and maps are always assumed to hold references.
+func canReference(t reflect.Type, config *Config) bool {
+	return countPointers(t, config, make(map[reflect.Type]bool)) >= pointerLimit
+}
+
+// countPointers returns the number of pointers that an Encodable for t can encode in one value, up to pointerLimit.
+func countPointers(t reflect.Type, config *Config, visiting map[reflect.Type]bool) (n int) {
+	if visiting[t] {
+		return pointerLimit
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	ptrt := reflect.PtrTo(t)
+	if ptrt.Implements(binaryMarshalerIface) && ptrt.Implements(binaryUnmarshalerIface) {
+		return 0
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		n = 1 + countPointers(t.Elem(), config, visiting)
+	case reflect.Interface:
+		n = pointerLimit
+	case reflect.Struct:
+		for _, f := range structFields(t, config) {
+			n += countPointers(f.Type, config, visiting)
+		}
+	case reflect.Array:
+		if t.Len() > 0 {
+			n = countPointers(t.Elem(), config, visiting)
+		}
+		if n > 0 && t.Len() > 1 {
+			n = pointerLimit
+		}
+	case reflect.Slice:
+		if config.Aliasing || countPointers(t.Elem(), config, visiting) > 0 {
+			n = pointerLimit
+		}
+	case reflect.Map:
+		if config.Aliasing || countPointers(t.Key(), config, visiting)+countPointers(t.Elem(), config, visiting) > 0 {
+			n = pointerLimit
+		}
 	}
 
-	enc := NewConcurrent(func() Encodable {
-		return newEncodable(t, state)
-	})
-	ref.encoders[t] = enc
-	return enc
+	if n > pointerLimit {
+		n = pointerLimit
+	}
+	return n
 }
 
-// encodeReference encodes the object at ptr once, writing references to the first encode in subsequent calls to encodeReference.
+// indexThreshold is the size of reference table above which find uses index instead of a linear scan.
+const indexThreshold = 32
+
+// encodeReference encodes the object of type t at ptr once, writing references to the first encode in subsequent calls to encodeReference.
 // Writes up to 10 bytes.
 // It is acceptable to pass a nil elem encodable with a nil pointer (but Encodables should know their sub-types beforehand).
-func (ref *referencer) encodeReference(ptr unsafe.Pointer, elem Encodable, w io.Writer) error {
+func (ref *referencer) encodeReference(ptr unsafe.Pointer, t reflect.Type, elem Encodable, w io.Writer) error {
 	if ptr == nil {
-		ref.buff[0] = refNil
-		return encio.Write(ref.buff[:], w)
+		return ref.writeTag(refNil, w)
 	}
 
-	if index, seen := ref.findPtr(ptr); seen {
-		ref.buff[0] = refReference
-		if err := encio.Write(ref.buff[:], w); err != nil {
+	if index, seen := ref.find(referenceKey{ptr: ptr, t: t}); seen {
+		if err := ref.writeTag(refReference, w); err != nil {
 			return err
 		}
 		return ref.intEnc.Encode(unsafe.Pointer(&index), w)
 	}
 
-	ref.append(ptr)
+	ref.append(reference{ptr: ptr, t: t})
 
-	ref.buff[0] = refEncoded
-	if err := encio.Write(ref.buff[:], w); err != nil {
+	if err := ref.writeTag(refEncoded, w); err != nil {
 		return err
 	}
 
@@ -80,87 +180,346 @@ func (ref *referencer) encodeReference(ptr unsafe.Pointer, elem Encodable, w io.
 }
 
 // decodeReference does the opposite of encodeReference, pointing ptr to the decoded object.
-func (ref *referencer) decodeReference(ptr *unsafe.Pointer, elem Encodable, r io.Reader) error {
+func (ref *referencer) decodeReference(ptr *unsafe.Pointer, t reflect.Type, elem Encodable, r io.Reader) error {
 	if ptr == nil {
 		return encio.ErrNilPointer
 	}
 
-	if err := encio.Read(ref.buff[:], r); err != nil {
+	tag, err := ref.readTag(r)
+	if err != nil {
 		return err
 	}
 
-	switch ref.buff[0] {
+	switch tag {
 	case refNil:
 		*ptr = nil
 		return nil
 	case refReference:
-		var index int
-		err := ref.intEnc.Decode(unsafe.Pointer(&index), r)
+		referenced, err := ref.readReference(t, false, r)
 		if err != nil {
 			return err
 		}
-		if index >= len(ref.references) {
-			return encio.IOError{
-				Err:     encio.ErrMalformed,
-				Message: fmt.Sprintf("object is stored by reference, but the referenced location doesnt exist"),
+
+		*ptr = referenced.ptr
+		return nil
+	}
+
+	// we must decode the type, and store a pointer to it.
+	// Values in a shared table must not be overwritten by later decodes, so they are always new.
+	if *ptr == nil || ref.shared != nil {
+		newAt(ptr, t)
+	}
+
+	ref.append(reference{ptr: *ptr, t: t}) // Must be before elem.Decode in case it calls us during its decode.
+	return elem.Decode(*ptr, r)
+}
+
+// encodeSlice encodes the slice of type t at ptr, writing a reference instead of its elements if it is part of a backing array that has already been encoded.
+// Backing arrays are encoded up to their capacity, so that later slices of them can be found.
+func (ref *referencer) encodeSlice(ptr unsafe.Pointer, t reflect.Type, elem Encodable, w io.Writer) error {
+	slice := (*sliceHeader)(ptr)
+	if slice.data == nil {
+		return ref.writeTag(refNil, w)
+	}
+
+	size := t.Elem().Size()
+	array := reference{ptr: slice.data, t: t, cap: slice.cap}
+	if size > 0 && slice.cap > 0 {
+		if index, seen := ref.find(array.key()); seen && uintptr(slice.data) >= uintptr(ref.references[index].ptr) {
+			offset := int((uintptr(slice.data) - uintptr(ref.references[index].ptr)) / size)
+			if err := ref.writeTag(refReference, w); err != nil {
+				return err
+			}
+			if err := ref.intEnc.Encode(unsafe.Pointer(&index), w); err != nil {
+				return err
 			}
+			return ref.writeLengths(w, offset, slice.len, slice.cap)
+		}
+	}
+
+	if slice.cap == 0 {
+		// empty slices can share their pointer with anything; they are never referenced.
+		array.ptr = nil
+	}
+	ref.append(array)
+
+	if err := ref.writeTag(refEncoded, w); err != nil {
+		return err
+	}
+	if err := ref.writeLengths(w, slice.len, slice.cap); err != nil {
+		return err
+	}
+
+	for i := 0; i < slice.cap; i++ {
+		if err := elem.Encode(unsafe.Pointer(uintptr(slice.data)+uintptr(i)*size), w); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// decodeSlice does the opposite of encodeSlice, allocating a new backing array for encoded slices.
+func (ref *referencer) decodeSlice(ptr unsafe.Pointer, t reflect.Type, elem Encodable, r io.Reader) error {
+	tag, err := ref.readTag(r)
+	if err != nil {
+		return err
+	}
+
+	slice := (*sliceHeader)(ptr)
+	size := t.Elem().Size()
 
-		*ptr = ref.references[index]
+	switch tag {
+	case refNil:
+		*slice = sliceHeader{}
 		return nil
-	case refEncoded:
-		break
+	case refReference:
+		array, err := ref.readReference(t, true, r)
+		if err != nil {
+			return err
+		}
+
+		var offset, l, c int
+		if err := ref.readLengths(r, array.cap, &offset, &l, &c); err != nil {
+			return err
+		}
+		if offset < 0 || l < 0 || l > c || offset+c > array.cap {
+			return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("slice [%v:%v:%v] is out of range of its %v element backing array", offset, offset+l, offset+c, array.cap), 0)
+		}
+
+		*slice = sliceHeader{
+			data: unsafe.Pointer(uintptr(array.ptr) + uintptr(offset)*size),
+			len:  l,
+			cap:  c,
+		}
+		return nil
+	}
 
+	// zero-sized elements still take time to decode.
+	limit := encio.TooBig
+	if size > 0 {
+		limit /= int(size)
+	}
+
+	var l, c int
+	if err := ref.readLengths(r, limit, &l, &c); err != nil {
+		return err
+	}
+	if l < 0 || l > c {
+		return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("slice length %v is larger than its capacity %v", l, c), 0)
+	}
+
+	// Must be before decoding elements in case they reference the backing array.
+	made := reflect.MakeSlice(t, l, c)
+	reflect.NewAt(t, ptr).Elem().Set(made)
+	if c == 0 {
+		ref.append(reference{t: t})
+		return nil
+	}
+	ref.append(reference{ptr: slice.data, t: t, cap: c})
+
+	for i := 0; i < c; i++ {
+		if err := elem.Decode(unsafe.Pointer(uintptr(slice.data)+uintptr(i)*size), r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMap encodes the map at ptr once, writing references to the first encode in subsequent calls to encodeMap.
+func (ref *referencer) encodeMap(ptr unsafe.Pointer, m *Map, w io.Writer) error {
+	header := *(*unsafe.Pointer)(ptr)
+	if header == nil {
+		return ref.writeTag(refNil, w)
+	}
+
+	if index, seen := ref.find(referenceKey{ptr: header, t: m.t}); seen {
+		if err := ref.writeTag(refReference, w); err != nil {
+			return err
+		}
+		return ref.intEnc.Encode(unsafe.Pointer(&index), w)
+	}
+
+	ref.append(reference{ptr: header, t: m.t})
+
+	if err := ref.writeTag(refEncoded, w); err != nil {
+		return err
+	}
+
+	return m.encodeEntries(reflect.NewAt(m.t, ptr).Elem(), w)
+}
+
+// decodeMap does the opposite of encodeMap, making a new map for encoded maps.
+func (ref *referencer) decodeMap(ptr unsafe.Pointer, m *Map, r io.Reader) error {
+	tag, err := ref.readTag(r)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.NewAt(m.t, ptr).Elem()
+
+	switch tag {
+	case refNil:
+		v.Set(reflect.Zero(m.t))
+		return nil
+	case refReference:
+		referenced, err := ref.readReference(m.t, false, r)
+		if err != nil {
+			return err
+		}
+
+		*(*unsafe.Pointer)(ptr) = referenced.ptr
+		return nil
+	}
+
+	l, err := m.decodeLen(r)
+	if err != nil {
+		return err
+	}
+
+	// Must be before decoding entries in case they reference the map.
+	// Aliased maps are never reused, as the map being decoded into could be held elsewhere.
+	v.Set(reflect.MakeMapWithSize(m.t, l))
+	ref.append(reference{ptr: *(*unsafe.Pointer)(ptr), t: m.t})
+
+	return m.decodeEntries(v, l, r)
+}
+
+func (ref *referencer) writeTag(tag uint8, w io.Writer) error {
+	ref.buff[0] = tag
+	return encio.Write(ref.buff[:], w)
+}
+
+// readTag reads a tag, returning an error if it isn't one of refNil, refReference or refEncoded.
+func (ref *referencer) readTag(r io.Reader) (uint8, error) {
+	if err := encio.Read(ref.buff[:], r); err != nil {
+		return 0, err
+	}
+
+	switch ref.buff[0] {
+	case refNil, refReference, refEncoded:
+		return ref.buff[0], nil
 	default:
-		return encio.IOError{
+		return 0, encio.IOError{
 			Err:     encio.ErrMalformed,
 			Message: fmt.Sprintf("reference type byte is not nil, reference or encoded"),
 		}
 	}
+}
 
-	// we must decode the type, and store a pointer to it
-	if *ptr == nil {
-		newAt(ptr, elem.Type())
+// readReference reads the index of a reference, returning the reference if it is a value of type t, or a backing array of slice type t if array is set.
+func (ref *referencer) readReference(t reflect.Type, array bool, r io.Reader) (reference, error) {
+	var index int
+	err := ref.intEnc.Decode(unsafe.Pointer(&index), r)
+	if err != nil {
+		return reference{}, err
+	}
+	if index < 0 || index >= len(ref.references) {
+		return reference{}, encio.IOError{
+			Err:     encio.ErrMalformed,
+			Message: fmt.Sprintf("object is stored by reference, but the referenced location doesnt exist"),
+		}
 	}
 
-	ref.append(*ptr) // Must be before elem.Decode in case it calls us during its decode.
-	return elem.Decode(*ptr, r)
+	referenced := ref.references[index]
+	if referenced.t != t || (referenced.cap > 0) != array || referenced.ptr == nil {
+		return reference{}, encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("reference to %v is not a %v", referenced.t, t), 0)
+	}
+	return referenced, nil
 }
 
-func (ref *referencer) findPtr(ptr unsafe.Pointer) (index int, ok bool) {
-	for i, p := range ref.references {
-		if p == ptr {
-			return i, true
+func (ref *referencer) writeLengths(w io.Writer, lengths ...int) error {
+	for _, l := range lengths {
+		if err := ref.lenEnc.Encode(w, uint32(l)); err != nil {
+			return err
 		}
 	}
-	return 0, false
+	return nil
 }
 
-func (ref *referencer) append(ptr unsafe.Pointer) {
-	l := len(ref.references)
-	c := cap(ref.references)
-	if l < c {
-		ref.references = ref.references[:l+1]
-		ref.references[l] = ptr
-		return
+// readLengths reads lengths written by writeLengths, returning an error if any is larger than limit.
+// They are checked before conversion to int, which can be 32 bits.
+func (ref *referencer) readLengths(r io.Reader, limit int, lengths ...*int) error {
+	for _, l := range lengths {
+		l32, err := ref.lenEnc.Decode(r)
+		if err != nil {
+			return err
+		}
+		if uint64(l32) > uint64(limit) {
+			return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("slice length or offset %v is larger than %v", l32, limit), 0)
+		}
+		*l = int(l32)
 	}
-	if c == 0 {
-		c = 8
+	return nil
+}
+
+// find returns the index of the reference with the given key.
+// Backing arrays can share a key; the latest one is returned.
+func (ref *referenceTable) find(key referenceKey) (index int, ok bool) {
+	if len(ref.references) <= indexThreshold {
+		for i := len(ref.references) - 1; i >= 0; i-- {
+			if ref.references[i].key() == key {
+				return i, true
+			}
+		}
+		return 0, false
 	}
-	nb := make([]unsafe.Pointer, c*2)
-	copy(ref.references, nb)
-	ref.references = nb[:l+1]
-	ref.references[l] = ptr
+
+	if ref.index == nil {
+		ref.index = make(map[referenceKey]int)
+	}
+	for ; ref.indexed < len(ref.references); ref.indexed++ {
+		key := ref.references[ref.indexed].key()
+		if key.ptr == nil {
+			continue
+		}
+		if shadowed, ok := ref.index[key]; ok {
+			ref.references[ref.indexed].shadows = shadowed + 1
+		}
+		ref.index[key] = ref.indexed
+	}
+
+	index, ok = ref.index[key]
 	return
 }
 
+// mark returns the current length of the reference table, for use with truncate.
+func (ref *referenceTable) mark() int {
+	return len(ref.references)
+}
+
+// truncate forgets all references added since mark returned n.
+// It is used when something is encoded or decoded speculatively.
+func (ref *referenceTable) truncate(n int) {
+	for ; ref.indexed > n; ref.indexed-- {
+		truncated := ref.references[ref.indexed-1]
+		key := truncated.key()
+		if key.ptr == nil {
+			continue
+		}
+		if truncated.shadows > 0 {
+			ref.index[key] = truncated.shadows - 1
+		} else {
+			delete(ref.index, key)
+		}
+	}
+	ref.references = ref.references[:n]
+}
+
+func (ref *referenceTable) append(r reference) {
+	ref.references = append(ref.references, r)
+}
+
 // referencer must know when each encode and decode ends. less we make all reference or compund type Encodables reset us every encode/decode,
 // in which case the top-level Encodable would have to know it's the parent, and act differently;
-// we make referencer wrap the first Encodable to request our presence.
+// we make referencer wrap the top-level Encodable, or failing that, the first Encodable to request our presence.
 // This relies on the fact that compund Encodable types will always execute child encodables in the same order.
 
 func (ref *referencer) String() string {
-	return "(referencer)" + ref.enc.String()
+	return ref.stringVisiting(make(map[*Concurrent]bool))
+}
+
+func (ref *referencer) stringVisiting(visiting map[*Concurrent]bool) string {
+	return "(referencer)" + stringOf(ref.enc, visiting)
 }
 
 func (ref *referencer) Size() int {
@@ -172,11 +531,75 @@ func (ref *referencer) Type() reflect.Type {
 }
 
 func (ref *referencer) Encode(ptr unsafe.Pointer, w io.Writer) error {
-	ref.references = ref.references[:0]
-	return ref.enc.Encode(ptr, w)
+	mark := ref.begin()
+	err := ref.enc.Encode(ptr, w)
+	ref.end(mark, err)
+	return err
 }
 
 func (ref *referencer) Decode(ptr unsafe.Pointer, r io.Reader) error {
-	ref.references = ref.references[:0]
-	return ref.enc.Decode(ptr, r)
+	mark := ref.begin()
+	err := ref.enc.Decode(ptr, r)
+	ref.end(mark, err)
+	return err
+}
+
+// begin must be called at the start of every top-level Encode and Decode, returning the mark to pass to end.
+func (ref *referencer) begin() int {
+	if ref.shared == nil {
+		ref.truncate(0)
+		return 0
+	}
+	return ref.shared.start()
+}
+
+// end must be called at the end of every top-level Encode and Decode. If err is non-nil, the references that were added are forgotten.
+func (ref *referencer) end(mark int, err error) {
+	if err != nil {
+		ref.truncate(mark)
+	}
+}
+
+// NewReferences returns a new, empty References.
+// If limit is greater than zero, the table is emptied at the start of an Encode or Decode once it holds more than limit values.
+func NewReferences(limit int) *References {
+	return &References{
+		limit: limit,
+	}
+}
+
+// References is a reference table that persists across calls to Encode and Decode, set with Config.References.
+// Values written through pointers are remembered, and pointers to them in later Encodes are written as references to the earlier write,
+// so the identity of values is kept across Encodes, and they are only sent once.
+// The Decoder must see the same Encodes in the same order, with a References of the same limit that is Reset at the same point.
+//
+// A value is not written again when it changes; a pointer to a value that has already been written is always written as a reference.
+// The table keeps the values in it from being garbage collected.
+// Encodables sharing a References must not be used concurrently, and if an Encode or Decode fails, the values it added are forgotten.
+type References struct {
+	table referenceTable
+	limit int
+}
+
+// Reset empties the table.
+func (r *References) Reset() {
+	r.table.truncate(0)
+}
+
+// Len returns the number of values in the table.
+func (r *References) Len() int {
+	return len(r.table.references)
+}
+
+// Limit returns the limit r was created with.
+func (r *References) Limit() int {
+	return r.limit
+}
+
+// start is called at the start of each Encode and Decode, returning the mark to truncate to if it fails.
+func (r *References) start() int {
+	if r.limit > 0 && len(r.table.references) > r.limit {
+		r.Reset()
+	}
+	return r.table.mark()
 }
@@ -0,0 +1,442 @@
+package encodable_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+// treeNode makes pointer graphs with many distinct pointers, and many references to earlier ones.
+type treeNode struct {
+	Left, Right *treeNode
+	Earlier     *treeNode
+	Value       int
+}
+
+// newTree returns a balanced tree of n nodes.
+// If shared is set, each node also points to a node created before it.
+func newTree(n int, shared bool) *treeNode {
+	nodes := make([]*treeNode, n)
+	for i := range nodes {
+		nodes[i] = &treeNode{
+			Value: i,
+		}
+		if i > 0 {
+			if shared {
+				nodes[i].Earlier = nodes[(i*7919)%i]
+			}
+			parent := nodes[(i-1)/2]
+			if i%2 == 1 {
+				parent.Left = nodes[i]
+			} else {
+				parent.Right = nodes[i]
+			}
+		}
+	}
+	return nodes[0]
+}
+
+func TestReferencesLarge(t *testing.T) {
+	for _, n := range []int{10, 1000, 20000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			tree := newTree(n, true)
+			enc := encodable.MustNew(reflect.TypeOf(tree), nil)
+
+			buff := new(bytes.Buffer)
+			for i := 0; i < 2; i++ {
+				if err := enc.Encode(unsafe.Pointer(&tree), buff); err != nil {
+					t.Fatalf("encode error: %v", err)
+				}
+			}
+
+			for i := 0; i < 2; i++ {
+				var decoded *treeNode
+				if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+					t.Fatalf("decode error: %v", err)
+				}
+
+				if diffs := encodable.Diff(enc, unsafe.Pointer(&tree), unsafe.Pointer(&decoded)); diffs != nil {
+					t.Fatalf("decoded tree differs: %v", diffs[0])
+				}
+			}
+
+			if buff.Len() != 0 {
+				t.Fatalf("%v bytes remaining after decode", buff.Len())
+			}
+		})
+	}
+}
+
+// BenchmarkReferences reports the time per pointer for encoding pointer graphs of different sizes,
+// which should stay roughly constant as the graphs grow.
+func BenchmarkReferences(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000, 100000} {
+		tree := newTree(n, true)
+		enc := encodable.MustNew(reflect.TypeOf(tree), nil)
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(unsafe.Pointer(&tree), ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*n*3), "ns/pointer")
+		})
+	}
+}
+
+func TestTreeShaped(t *testing.T) {
+	tree := newTree(100, false)
+	referencing := encodable.MustNew(reflect.TypeOf(tree), nil)
+	treeShaped := encodable.MustNew(reflect.TypeOf(tree), &encodable.Config{TreeShaped: true})
+
+	var withRefs, withoutRefs bytes.Buffer
+	if err := referencing.Encode(unsafe.Pointer(&tree), &withRefs); err != nil {
+		t.Fatal(err)
+	}
+	if err := treeShaped.Encode(unsafe.Pointer(&tree), &withoutRefs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without shared pointers, the reference tags are the same as the nil and non-nil bytes.
+	if !bytes.Equal(withRefs.Bytes(), withoutRefs.Bytes()) {
+		t.Fatalf("tree-shaped encoding %x differs from referencing encoding %x", withoutRefs.Bytes(), withRefs.Bytes())
+	}
+
+	var decoded *treeNode
+	if err := treeShaped.Decode(unsafe.Pointer(&decoded), &withoutRefs); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := encodable.Diff(treeShaped, unsafe.Pointer(&tree), unsafe.Pointer(&decoded)); diffs != nil {
+		t.Fatalf("decoded tree differs: %v", diffs[0])
+	}
+
+	// shared pointers are copied.
+	shared := &treeNode{Value: 1}
+	shared.Left = &treeNode{Value: 2}
+	shared.Right = shared.Left
+
+	buff := new(bytes.Buffer)
+	if err := treeShaped.Encode(unsafe.Pointer(&shared), buff); err != nil {
+		t.Fatal(err)
+	}
+	decoded = nil
+	if err := treeShaped.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Left == decoded.Right || decoded.Left.Value != 2 || decoded.Right.Value != 2 {
+		t.Errorf("got %v and %v, want distinct copies of %v", decoded.Left, decoded.Right, shared.Left)
+	}
+}
+
+func TestTreeShapedInterface(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	config := &encodable.Config{Resolver: resolver, TreeShaped: true}
+
+	v := []interface{}{"a", 1, nil, &treeNode{Value: 3}}
+	enc := encodable.MustNew(reflect.TypeOf(v), config)
+
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(unsafe.Pointer(&v), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []interface{}
+	if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := encodable.Diff(enc, unsafe.Pointer(&v), unsafe.Pointer(&decoded)); diffs != nil {
+		t.Fatalf("decoded value differs: %v", diffs)
+	}
+}
+
+func TestReferencesInterface(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	encConfig := &encodable.Config{Resolver: resolver, References: encodable.NewReferences(0)}
+	decConfig := &encodable.Config{Resolver: resolver, References: encodable.NewReferences(0)}
+
+	node := &treeNode{Value: 1}
+	v := []interface{}{node, node, 2}
+	enc := encodable.MustNew(reflect.TypeOf(v), encConfig)
+	dec := encodable.MustNew(reflect.TypeOf(v), decConfig)
+
+	buff := new(bytes.Buffer)
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(unsafe.Pointer(&v), buff); err != nil {
+			t.Fatal(err)
+		}
+		// the copies of the interfaces' values mustn't be kept; only node is.
+		if n := encConfig.References.Len(); n != 1 {
+			t.Fatalf("encode %v: reference table holds %v values, want 1", i, n)
+		}
+
+		var decoded []interface{}
+		if err := dec.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+			t.Fatal(err)
+		}
+		if n := decConfig.References.Len(); n != 1 {
+			t.Fatalf("decode %v: reference table holds %v values, want 1", i, n)
+		}
+		if diffs := encodable.Diff(enc, unsafe.Pointer(&v), unsafe.Pointer(&decoded)); diffs != nil {
+			t.Fatalf("decode %v: decoded value differs: %v", i, diffs)
+		}
+	}
+}
+
+func TestCanReference(t *testing.T) {
+	testCases := []struct {
+		value  interface{}
+		config *encodable.Config
+		refs   bool
+	}{
+		{new(int), nil, false},
+		{struct{ A *int }{}, nil, false},
+		{struct {
+			A *int
+			b *int
+		}{}, nil, false},
+		{struct {
+			A *int
+			b *int
+		}{}, &encodable.Config{IncludeUnexported: true}, true},
+		{struct{ A, B *int }{}, nil, true},
+		{[1]*int{}, nil, false},
+		{[2]*int{}, nil, true},
+		{[]*int{}, nil, true},
+		{map[string]*int{}, nil, true},
+		{new(*int), nil, true},
+		{new(treeNode), nil, true},
+		{[]int{}, nil, false},
+	}
+	for _, tC := range testCases {
+		ty := reflect.TypeOf(tC.value)
+		t.Run(fmt.Sprintf("%v %v", ty, tC.config), func(t *testing.T) {
+			if refs := encodable.CanReference(ty, tC.config); refs != tC.refs {
+				t.Errorf("got %v, want %v", refs, tC.refs)
+			}
+		})
+	}
+}
+
+// BenchmarkTreeShaped compares encoding and decoding trees with and without Config.TreeShaped.
+func BenchmarkTreeShaped(b *testing.B) {
+	tree := newTree(1000, false)
+	for _, bC := range []struct {
+		name   string
+		config *encodable.Config
+	}{
+		{"Referencing", nil},
+		{"TreeShaped", &encodable.Config{TreeShaped: true}},
+	} {
+		enc := encodable.MustNew(reflect.TypeOf(tree), bC.config)
+		buff := new(bytes.Buffer)
+		if err := enc.Encode(unsafe.Pointer(&tree), buff); err != nil {
+			b.Fatal(err)
+		}
+		encoded := buff.Bytes()
+
+		b.Run(bC.name+"/Encode", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(unsafe.Pointer(&tree), ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(bC.name+"/Decode", func(b *testing.B) {
+			r := bytes.NewReader(encoded)
+			for i := 0; i < b.N; i++ {
+				r.Reset(encoded)
+				var decoded *treeNode
+				if err := enc.Decode(unsafe.Pointer(&decoded), r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type aliasStruct struct {
+	A, B, C []int
+	M, N    map[string]int
+	Nil     []int
+	NilMap  map[string]int
+}
+
+func TestAliasing(t *testing.T) {
+	backing := make([]int, 4, 8)
+	for i := range backing {
+		backing[i] = i
+	}
+	m := map[string]int{"a": 1}
+	v := aliasStruct{
+		A: backing,
+		B: backing,
+		C: backing[2:6],
+		M: m,
+		N: m,
+	}
+
+	config := &encodable.Config{Aliasing: true}
+	enc := encodable.MustNew(reflect.TypeOf(v), config)
+
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(unsafe.Pointer(&v), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded aliasStruct
+	if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := encodable.Diff(enc, unsafe.Pointer(&v), unsafe.Pointer(&decoded)); diffs != nil {
+		t.Fatalf("decoded value differs: %v", diffs)
+	}
+
+	if &decoded.A[0] != &decoded.B[0] {
+		t.Errorf("A and B don't share a backing array")
+	}
+	if &decoded.A[2] != &decoded.C[0] || cap(decoded.C) != 6 {
+		t.Errorf("C isn't A[2:6]")
+	}
+	if len(decoded.A) != 4 || cap(decoded.A) != 8 {
+		t.Errorf("A has length %v and capacity %v, want 4 and 8", len(decoded.A), cap(decoded.A))
+	}
+	decoded.M["b"] = 2
+	if decoded.N["b"] != 2 {
+		t.Errorf("M and N are different maps")
+	}
+	if decoded.Nil != nil || decoded.NilMap != nil {
+		t.Errorf("nil slices and maps decoded as non-nil")
+	}
+
+	var cloned aliasStruct
+	if err := encodable.Clone(enc, unsafe.Pointer(&cloned), unsafe.Pointer(&v)); err != nil {
+		t.Fatal(err)
+	}
+	if &cloned.A[0] != &cloned.B[0] || &cloned.A[2] != &cloned.C[0] || &cloned.A[0] == &v.A[0] {
+		t.Errorf("clone doesn't reproduce slice aliasing")
+	}
+	cloned.M["c"] = 3
+	if cloned.N["c"] != 3 || v.M["c"] == 3 {
+		t.Errorf("clone doesn't reproduce map aliasing")
+	}
+
+	// decoded.M now has an extra entry, and an equal copy of a shared slice differs in its reference structure.
+	decoded.B = make([]int, 4, 8)
+	copy(decoded.B, decoded.A)
+	diffs := encodable.Diff(enc, unsafe.Pointer(&v), unsafe.Pointer(&decoded))
+	if len(diffs) != 2 || diffs[0].Path != ".B" || diffs[1].Path != ".M" {
+		t.Errorf("got differences %v, want differences in B and M", diffs)
+	}
+}
+
+func TestSharedAcrossElements(t *testing.T) {
+	shared := 5
+	v := []*int{&shared, &shared}
+	enc := encodable.MustNew(reflect.TypeOf(v), nil)
+
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(unsafe.Pointer(&v), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []*int
+	if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0] != decoded[1] || *decoded[0] != 5 {
+		t.Errorf("got %v, want two pointers to the same 5", decoded)
+	}
+}
+
+type truncateStruct struct {
+	A []*int
+	B *truncatePair
+	C map[int]truncateEntry
+}
+
+type truncatePair struct {
+	X, Y int
+}
+
+type truncateEntry struct {
+	P, Q *int
+	R    *truncatePair
+}
+
+// TestTruncateIndexed checks that references survive the truncation of later references to the same pointer,
+// as canonical maps do after encoding each entry, once the reference table is indexed.
+func TestTruncateIndexed(t *testing.T) {
+	pair := &truncatePair{X: 1, Y: 2}
+	v := truncateStruct{
+		B: pair,
+		C: map[int]truncateEntry{
+			// written first, after C[1] has been encoded and truncated.
+			0: {R: pair},
+			// P shares its pointer with B, but is an *int. Q makes the table index P before it is truncated.
+			1: {P: &pair.X, Q: new(int)},
+		},
+	}
+	for i := 0; i < 40; i++ {
+		i := i
+		v.A = append(v.A, &i)
+	}
+
+	enc := encodable.MustNew(reflect.TypeOf(v), &encodable.Config{Canonical: true})
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(unsafe.Pointer(&v), buff); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded truncateStruct
+	if err := enc.Decode(unsafe.Pointer(&decoded), buff); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := encodable.Diff(enc, unsafe.Pointer(&v), unsafe.Pointer(&decoded)); diffs != nil {
+		t.Fatalf("decoded value differs: %v", diffs)
+	}
+	if decoded.B != decoded.C[0].R {
+		t.Errorf("B and C[0].R point to different values")
+	}
+}
+
+type aliasPair struct {
+	A, B []int8
+}
+
+func TestAliasingMalformed(t *testing.T) {
+	// A is []int8{1, 2, 3}; B is a reference to it, followed by the offset, length and capacity.
+	array := []byte{0x04, 0x03, 0x03, 0x01, 0x02, 0x03, 0x02, 0x00}
+	huge := []byte{0xfc, 0xff, 0xff, 0xff, 0xff}
+
+	testCases := []struct {
+		desc string
+		ty   reflect.Type
+		data []byte
+	}{
+		{"huge offset", reflect.TypeOf(aliasPair{}), append(append(array, huge...), 0x01, 0x01)},
+		{"huge length", reflect.TypeOf(aliasPair{}), append(append(append(array, 0x00), huge...), huge...)},
+		{"out of range", reflect.TypeOf(aliasPair{}), append(array, 0x02, 0x01, 0x02)},
+		{"huge zero-sized", reflect.TypeOf([]struct{}{}), append(append([]byte{0x04}, huge...), huge...)},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			enc := encodable.MustNew(tC.ty, &encodable.Config{Aliasing: true})
+			decoded := reflect.New(tC.ty)
+			err := enc.Decode(unsafe.Pointer(decoded.Pointer()), bytes.NewReader(tC.data))
+			if !errors.Is(err, encio.ErrMalformed) {
+				t.Fatalf("got error %v, want %v", err, encio.ErrMalformed)
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package encodable
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
 	"hash/crc64"
 	"io"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/stewi1014/encs/encio"
@@ -48,16 +53,108 @@ var (
 	ErrNotRegistered = errors.New("not registered")
 )
 
-// NewRegisterResolver returns a new RegisterResolver TypeResolver
+// NewRegisterResolver returns a new RegisterResolver TypeResolver, with 8-byte IDs from hasher.
+// If hasher is nil, CRC-64 with the ISO polynomial is used.
 func NewRegisterResolver(hasher hash.Hash64) *RegisterResolver {
-	if hasher == nil {
-		hasher = crc64.New(crc64.MakeTable(crc64.ISO))
+	return NewRegisterResolverWithConfig(&RegisterConfig{
+		Hasher: hasher,
+	})
+}
+
+// RegisterConfig contains settings for a RegisterResolver.
+//
+// It *must* be the same for the Encoder's and Decoder's RegisterResolver.
+type RegisterConfig struct {
+	// Hasher is used to hash types into IDs.
+	// If nil, CRC-64 with the ISO polynomial is used.
+	//
+	// IDs from a hash.Hash64 are the little-endian bytes of Sum64, and those from other hashes are the output of Sum.
+	// A cryptographic hash such as SHA-256 makes collisions between peers practically impossible, at the cost of larger IDs.
+	Hasher hash.Hash
+
+	// IDSize is the size of IDs in bytes, and the value of Size.
+	// Hashes are truncated to IDSize. If zero, 8 bytes are used for a hash.Hash64, and the whole hash otherwise.
+	// It can't be larger than the hash, or 64; sizes that are, or are negative, are treated as zero.
+	IDSize int
+
+	// Structural mixes a description of the type's structure into its ID; its field names, types and order, recursively.
+	// By default IDs are a hash of the type's name alone, so two programs with different versions of a struct
+	// give it the same ID, and decode each other's data incorrectly.
+	// With Structural set they give it different IDs, and the mismatch is returned as an ErrNotRegistered error.
+	Structural bool
+
+	// Policy decides whether types can be registered implicitly, as they are encoded or decoded.
+	// The default is PolicyPermissive.
+	Policy RegisterPolicy
+
+	// Packages lists the import paths of the packages PolicyAllowPackages allows types from.
+	// A path ending in "/..." also allows its sub-packages, as in go tool patterns.
+	Packages []string
+
+	// Unknown, if non-nil, is called when Decode reads an ID that isn't registered, before Policy is applied.
+	// expected is the type Decode was given, and may be nil.
+	// If Unknown returns an error, Decode returns it.
+	Unknown func(id []byte, expected reflect.Type) error
+}
+
+// RegisterPolicy decides whether a RegisterResolver registers types implicitly.
+// Types registered explicitly, with Register, RegisterName or RegisterID, are always allowed.
+type RegisterPolicy int
+
+const (
+	// PolicyPermissive registers types as they are used.
+	// Encode registers unknown types and writes their ID,
+	// and Decode registers the expected type if the read ID is the ID the expected type would have.
+	PolicyPermissive RegisterPolicy = iota
+
+	// PolicyStrict never registers types implicitly.
+	// Encode returns an ErrNotRegistered error without writing anything for unknown types,
+	// and Decode returns an ErrNotRegistered error for unknown IDs.
+	PolicyStrict
+
+	// PolicyAllowPackages is PolicyPermissive for types from the packages in RegisterConfig.Packages, and PolicyStrict for others.
+	// Unnamed types, such as []T and map[K]V, are allowed if all the named types they are made of are.
+	// Predeclared types, such as int and string, are always allowed.
+	PolicyAllowPackages
+)
+
+// String implements fmt.Stringer
+func (p RegisterPolicy) String() string {
+	switch p {
+	case PolicyPermissive:
+		return "PolicyPermissive"
+	case PolicyStrict:
+		return "PolicyStrict"
+	case PolicyAllowPackages:
+		return "PolicyAllowPackages"
+	default:
+		return fmt.Sprintf("RegisterPolicy(%d)", int(p))
 	}
+}
 
+// NewRegisterResolverWithConfig returns a new RegisterResolver TypeResolver with the given config.
+func NewRegisterResolverWithConfig(config *RegisterConfig) *RegisterResolver {
 	rr := &RegisterResolver{
-		hasher:   hasher,
-		idByType: make(map[reflect.Type][8]byte),
-		typeByID: make(map[[8]byte]reflect.Type),
+		idByType: make(map[reflect.Type]string),
+		typeByID: make(map[string]reflect.Type),
+		nameByID: make(map[string]string),
+	}
+	if config != nil {
+		rr.config = *config
+	}
+	if rr.config.Hasher == nil {
+		rr.config.Hasher = crc64.New(crc64.MakeTable(crc64.ISO))
+	}
+
+	hashSize := rr.config.Hasher.Size()
+	if _, ok := rr.config.Hasher.(hash.Hash64); ok {
+		hashSize = 8
+	}
+	if hashSize > maxIDSize {
+		hashSize = maxIDSize
+	}
+	if rr.config.IDSize <= 0 || rr.config.IDSize > hashSize {
+		rr.config.IDSize = hashSize
 	}
 
 	for _, T := range builtin {
@@ -72,40 +169,46 @@ func NewRegisterResolver(hasher hash.Hash64) *RegisterResolver {
 // RegisterResolver is a registration-based TypeResolver.
 // All types to be encoded and decoded must be registered with Register(),
 // with the exception of int*, uint*, float*, complex*, string, bool, time.Time, and time.Duration, which are pre-registered.
+// Unregistered types are registered as they are used, or refused, according to RegisterConfig.Policy.
 // It is thread safe.
 type RegisterResolver struct {
-	hasher      hash.Hash64
+	config      RegisterConfig
 	hasherMutex sync.Mutex
 
-	idByType map[reflect.Type][8]byte
-	typeByID map[[8]byte]reflect.Type
+	// IDs are stored as strings so they can be used as map keys.
+	idByType map[reflect.Type]string
+	typeByID map[string]reflect.Type
+	nameByID map[string]string // the names IDs were registered with, for Export and Import.
 	mapMutex sync.Mutex
 }
 
+// maxIDSize is the largest ID size; that of SHA-512.
+const maxIDSize = 64
+
 // Register registers T, &T, []T, and *T if T is a pointer.
+// T can be a value of the type, or its reflect.Type.
+//
+// Types are identified by a hash of their name, as given by Name.
+// If T is already registered under another ID, the new IDs are aliases; they decode to T, but T is still encoded with its first ID.
 func (rr *RegisterResolver) Register(T interface{}) error {
-	var ty reflect.Type
-	var ok bool
-	if ty, ok = T.(reflect.Type); !ok {
-		ty = reflect.TypeOf(T)
-	}
+	ty := typeOf(T)
 
 	if err := rr.hashAndPut(ty); err != nil {
 		return err
 	}
 
 	st := reflect.SliceOf(ty)
-	if err := rr.hashAndPut(st); err != nil && err != ErrAlreadyRegistered {
+	if err := rr.hashAndPut(st); err != nil && !errors.Is(err, ErrAlreadyRegistered) {
 		return err
 	}
 
 	pt := reflect.PtrTo(ty)
-	if err := rr.hashAndPut(pt); err != nil && err != ErrAlreadyRegistered {
+	if err := rr.hashAndPut(pt); err != nil && !errors.Is(err, ErrAlreadyRegistered) {
 		return err
 	}
 
 	if ty.Kind() == reflect.Ptr {
-		if err := rr.hashAndPut(ty.Elem()); err != nil && err != ErrAlreadyRegistered {
+		if err := rr.hashAndPut(ty.Elem()); err != nil && !errors.Is(err, ErrAlreadyRegistered) {
 			return err
 		}
 	}
@@ -113,109 +216,377 @@ func (rr *RegisterResolver) Register(T interface{}) error {
 	return nil
 }
 
+// RegisterDeep registers T as Register does, along with every named type reachable from T;
+// the types of struct fields, map keys and values, and array, slice and pointer elements, recursively.
+// Unexported struct fields are followed too, as they're encoded when Config.IncludeUnexported is set.
+// Types encoded with encoding.BinaryMarshaler are registered, but not walked.
+//
+// Types that are already registered are skipped.
+func (rr *RegisterResolver) RegisterDeep(T interface{}) error {
+	ty := typeOf(T)
+	if err := rr.Register(ty); err != nil && !errors.Is(err, ErrAlreadyRegistered) {
+		return err
+	}
+	return rr.registerDeep(ty, make(map[reflect.Type]bool))
+}
+
+func (rr *RegisterResolver) registerDeep(ty reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[ty] {
+		return nil
+	}
+	seen[ty] = true
+
+	if ty.Name() != "" {
+		if err := rr.Register(ty); err != nil && !errors.Is(err, ErrAlreadyRegistered) {
+			return err
+		}
+
+		ptrt := reflect.PtrTo(ty)
+		if ptrt.Implements(binaryMarshalerIface) && ptrt.Implements(binaryUnmarshalerIface) {
+			return nil
+		}
+	}
+
+	switch ty.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return rr.registerDeep(ty.Elem(), seen)
+	case reflect.Map:
+		if err := rr.registerDeep(ty.Key(), seen); err != nil {
+			return err
+		}
+		return rr.registerDeep(ty.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < ty.NumField(); i++ {
+			if err := rr.registerDeep(ty.Field(i).Type, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RegisterName is like Register, but identifies T by name instead of its Go name, similar to gob.RegisterName.
+// IDs from RegisterName survive moving and renaming T, as long as the same name is registered on both sides.
+// []T and *T are registered as "[]"+name and "*"+name.
+//
+// Registering a second name for T gives it an alias; for example, an old name can be kept to decode data written before T was renamed.
+// RegisterConfig.Structural does not apply to named types.
+func (rr *RegisterResolver) RegisterName(name string, T interface{}) error {
+	ty := typeOf(T)
+
+	names := []struct {
+		name string
+		ty   reflect.Type
+	}{
+		{name, ty},
+		{"[]" + name, reflect.SliceOf(ty)},
+		{"*" + name, reflect.PtrTo(ty)},
+	}
+
+	for i, n := range names {
+		h, err := rr.hashString(n.name)
+		if err != nil {
+			return err
+		}
+		if err := rr.put(n.ty, h, n.name); err != nil && (i == 0 || !errors.Is(err, ErrAlreadyRegistered)) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterID registers T with the given ID, for callers that manage their own IDs.
+// id must be Size() bytes long. Unlike Register, only T is registered.
+//
+// Registering a second ID for T gives it an alias, as in RegisterName.
+func (rr *RegisterResolver) RegisterID(id []byte, T interface{}) error {
+	if len(id) != rr.config.IDSize {
+		return encio.NewError(encio.ErrBadConfig, fmt.Sprintf("ID %x is %v bytes, but IDs are %v bytes", id, len(id), rr.config.IDSize), 0)
+	}
+
+	ty := typeOf(T)
+	return rr.put(ty, string(id), Name(ty))
+}
+
+// typeOf returns T if it is a reflect.Type, or its type otherwise.
+func typeOf(T interface{}) reflect.Type {
+	if ty, ok := T.(reflect.Type); ok {
+		return ty
+	}
+	return reflect.TypeOf(T)
+}
+
 func (rr *RegisterResolver) hashAndPut(ty reflect.Type) error {
 	h, err := rr.hash(ty)
 	if err != nil {
 		return err
 	}
-	return rr.put(ty, h)
+	return rr.put(ty, h, Name(ty))
+}
+
+func (rr *RegisterResolver) hash(ty reflect.Type) (string, error) {
+	if rr.config.Structural {
+		return rr.hashString(Name(ty) + " " + layout(ty))
+	}
+	return rr.hashString(Name(ty))
 }
 
-func (rr *RegisterResolver) hash(ty reflect.Type) (out [8]byte, err error) {
+func (rr *RegisterResolver) hashString(str string) (string, error) {
 	rr.hasherMutex.Lock()
 	defer rr.hasherMutex.Unlock()
-	rr.hasher.Reset()
-	buff := []byte(Name(ty))
-	n, err := rr.hasher.Write(buff)
+	rr.config.Hasher.Reset()
+	buff := []byte(str)
+	n, err := rr.config.Hasher.Write(buff)
 	if err != nil {
-		return out, encio.NewError(err, "hash error", 0)
+		return "", encio.NewError(err, "hash error", 0)
 	}
 	if n != len(buff) {
-		return out, encio.NewError(io.ErrShortWrite, fmt.Sprintf("wrote %v, want %v", n, len(buff)), 0)
+		return "", encio.NewError(io.ErrShortWrite, fmt.Sprintf("wrote %v, want %v", n, len(buff)), 0)
+	}
+
+	var out []byte
+	if h64, ok := rr.config.Hasher.(hash.Hash64); ok {
+		h := h64.Sum64()
+		out = []byte{
+			uint8(h),
+			uint8(h >> 8),
+			uint8(h >> 16),
+			uint8(h >> 24),
+			uint8(h >> 32),
+			uint8(h >> 40),
+			uint8(h >> 48),
+			uint8(h >> 56),
+		}
+	} else {
+		out = rr.config.Hasher.Sum(nil)
 	}
-	h := rr.hasher.Sum64()
-	out[0] = uint8(h)
-	out[1] = uint8(h >> 8)
-	out[2] = uint8(h >> 16)
-	out[3] = uint8(h >> 24)
-	out[4] = uint8(h >> 32)
-	out[5] = uint8(h >> 40)
-	out[6] = uint8(h >> 48)
-	out[7] = uint8(h >> 56)
-	return
+	return string(out[:rr.config.IDSize]), nil
 }
 
-func (rr *RegisterResolver) put(ty reflect.Type, h [8]byte) error {
+func (rr *RegisterResolver) put(ty reflect.Type, h string, name string) error {
 	rr.mapMutex.Lock()
 	defer rr.mapMutex.Unlock()
 	if oty, ok := rr.typeByID[h]; ok {
 		if oty == ty {
 			return encio.NewError(ErrAlreadyRegistered, fmt.Sprintf("type %v", ty), 1)
 		}
-		return encio.NewError(ErrAlreadyRegistered, fmt.Sprintf("hash of %v and %v are both %v", ty, oty, h), 1)
+		return encio.NewError(ErrAlreadyRegistered, fmt.Sprintf("hash of %v and %v are both %x", ty, oty, h), 1)
 	}
 	rr.typeByID[h] = ty
-	rr.idByType[ty] = h
+	rr.nameByID[h] = name
+	if _, ok := rr.idByType[ty]; !ok {
+		// IDs after the first are aliases.
+		rr.idByType[ty] = h
+	}
 	return nil
 }
 
-func (rr *RegisterResolver) getByType(ty reflect.Type) ([8]byte, bool) {
+func (rr *RegisterResolver) getByType(ty reflect.Type) (string, bool) {
 	rr.mapMutex.Lock()
 	h, ok := rr.idByType[ty]
 	rr.mapMutex.Unlock()
 	return h, ok
 }
 
-func (rr *RegisterResolver) getByID(h [8]byte) (reflect.Type, bool) {
+func (rr *RegisterResolver) getByID(h string) (reflect.Type, bool) {
 	rr.mapMutex.Lock()
 	ty, ok := rr.typeByID[h]
 	rr.mapMutex.Unlock()
 	return ty, ok
 }
 
+// Types returns the registered types, sorted by name.
+// Types with aliases are only returned once.
+func (rr *RegisterResolver) Types() []reflect.Type {
+	rr.mapMutex.Lock()
+	types := make([]reflect.Type, 0, len(rr.idByType))
+	for ty := range rr.idByType {
+		types = append(types, ty)
+	}
+	rr.mapMutex.Unlock()
+
+	sort.Slice(types, func(i, j int) bool {
+		return Name(types[i]) < Name(types[j])
+	})
+	return types
+}
+
+// Lookup returns the type registered with the given ID.
+func (rr *RegisterResolver) Lookup(id []byte) (reflect.Type, bool) {
+	return rr.getByID(string(id))
+}
+
+// ID returns the ID t is encoded with.
+func (rr *RegisterResolver) ID(t reflect.Type) ([]byte, bool) {
+	id, ok := rr.getByType(t)
+	if !ok {
+		return nil, false
+	}
+	return []byte(id), true
+}
+
+// Unregister removes T and all its IDs, including aliases.
+// Types registered alongside T, such as []T and *T by Register, stay registered.
+// T can be a value of the type, or its reflect.Type.
+func (rr *RegisterResolver) Unregister(T interface{}) error {
+	ty := typeOf(T)
+
+	rr.mapMutex.Lock()
+	defer rr.mapMutex.Unlock()
+	if _, ok := rr.idByType[ty]; !ok {
+		return encio.NewError(ErrNotRegistered, fmt.Sprintf("cannot unregister %v", ty), 0)
+	}
+
+	delete(rr.idByType, ty)
+	for id, oty := range rr.typeByID {
+		if oty == ty {
+			delete(rr.typeByID, id)
+			delete(rr.nameByID, id)
+		}
+	}
+	return nil
+}
+
+// Export writes the table of registered IDs and the names they were registered with to w, sorted by name then ID.
+// Each line is an ID in hex, a space, then the name; Name(T) for types registered with Register, or the name given to RegisterName.
+// Aliases are written as their own lines.
+func (rr *RegisterResolver) Export(w io.Writer) error {
+	type entry struct {
+		id   string
+		name string
+	}
+
+	rr.mapMutex.Lock()
+	entries := make([]entry, 0, len(rr.nameByID))
+	for id, name := range rr.nameByID {
+		entries = append(entries, entry{id, name})
+	}
+	rr.mapMutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	buff := new(bytes.Buffer)
+	for _, e := range entries {
+		fmt.Fprintf(buff, "%x %v\n", e.id, e.name)
+	}
+	return encio.Write(buff.Bytes(), w)
+}
+
+// Import reads a table written by Export, registering its IDs for the types registered here under the same names.
+// IDs that differ from the local ones become aliases, so data from the exporting resolver can be decoded.
+// Names that aren't registered here are ignored.
+// If an ID is registered here for a different type, an ErrAlreadyRegistered error is returned after importing the other IDs.
+func (rr *RegisterResolver) Import(r io.Reader) error {
+	rr.mapMutex.Lock()
+	byName := make(map[string]reflect.Type, len(rr.nameByID))
+	for id, name := range rr.nameByID {
+		byName[name] = rr.typeByID[id]
+	}
+	rr.mapMutex.Unlock()
+
+	var errs []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		idSize := rr.config.IDSize
+		if len(text) < 2*idSize+1 || text[2*idSize] != ' ' {
+			return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("line %v of table is %q, want %v byte ID then name", line, text, idSize), 0)
+		}
+		id, err := hex.DecodeString(text[:2*idSize])
+		if err != nil {
+			return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("line %v of table: %v", line, err), 0)
+		}
+		name := text[2*idSize+1:]
+
+		ty, ok := byName[name]
+		if !ok {
+			continue
+		}
+
+		if err := rr.put(ty, string(id), name); err != nil {
+			if oty, _ := rr.getByID(string(id)); oty != ty {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return encio.NewIOError(err, r, "reading table", 0)
+	}
+
+	if len(errs) > 0 {
+		return encio.NewError(ErrAlreadyRegistered, strings.Join(errs, ", "), 0)
+	}
+	return nil
+}
+
 // Size implements TypeResolver
 func (rr *RegisterResolver) Size() int {
-	return 8
+	return rr.config.IDSize
 }
 
 // Encode implements TypeResolver
 func (rr *RegisterResolver) Encode(ty reflect.Type, w io.Writer) error {
 	if h, ok := rr.getByType(ty); ok {
-		return encio.Write(h[:], w)
+		return encio.Write([]byte(h), w)
 	}
 
 	// ty is not registered.
-	// register it now and encode, returning an ErrNotRegistered error.
+	if !rr.implicit(ty) {
+		return encio.NewError(ErrNotRegistered, fmt.Sprintf("%v is not registered, and %v doesn't allow registering it implicitly", ty, rr.config.Policy), 0)
+	}
+
 	h, err := rr.hash(ty)
 	if err != nil {
 		return encio.NewError(ErrNotRegistered, fmt.Sprintf("%v not previously registered. registering now failed with %v", ty, err), 0)
 	}
 
-	err = rr.put(ty, h)
+	err = rr.put(ty, h, Name(ty))
 	if err != nil {
 		return encio.NewError(ErrNotRegistered, fmt.Sprintf("%v not previously registered. registering now failed with %v", ty, err), 0)
 	}
 
-	err = encio.Write(h[:], w)
-	if err != nil {
-		return encio.NewError(ErrNotRegistered, fmt.Sprintf("%v not previously registered but registered now. writing failed with %v", ty, err), 0)
-	}
-	return encio.NewError(ErrNotRegistered, fmt.Sprintf("%v written but not previously registered. decoder may not understand it", ty), 0)
+	return encio.Write([]byte(h), w)
 }
 
 // Decode implements TypeResolver
 func (rr *RegisterResolver) Decode(expected reflect.Type, r io.Reader) (reflect.Type, error) {
-	var h [8]byte
-	if err := encio.Read(h[:], r); err != nil {
+	var buff [maxIDSize]byte
+	id := buff[:rr.config.IDSize]
+	if err := encio.Read(id, r); err != nil {
 		return nil, err
 	}
 
-	if ty, ok := rr.getByID(h); ok {
+	// indexing with string(id) doesn't allocate.
+	rr.mapMutex.Lock()
+	ty, ok := rr.typeByID[string(id)]
+	rr.mapMutex.Unlock()
+	if ok {
 		return ty, nil
 	}
 
+	h := string(id)
+	if rr.config.Unknown != nil {
+		if err := rr.config.Unknown([]byte(h), expected); err != nil {
+			return nil, err
+		}
+	}
+
+	if expected != nil && !rr.implicit(expected) {
+		return nil, encio.NewError(ErrNotRegistered, fmt.Sprintf("received hash %x doesn't map to any known types, and %v doesn't allow registering the expected type %v implicitly", h, rr.config.Policy, expected), 0)
+	}
+
 	if expected == nil {
-		return nil, encio.NewError(ErrNotRegistered, fmt.Sprintf("received hash %v doesn't map to any known types. Is it registered?", h), 0)
+		return nil, encio.NewError(ErrNotRegistered, fmt.Sprintf("received hash %x doesn't map to any known types. Is it registered?", h), 0)
 	}
 
 	eh, err := rr.hash(expected)
@@ -223,12 +594,57 @@ func (rr *RegisterResolver) Decode(expected reflect.Type, r io.Reader) (reflect.
 		return nil, encio.NewError(err, "couldn't hash expected type", 0)
 	}
 	if eh != h {
-		return nil, encio.NewError(ErrNotRegistered, fmt.Sprintf("received hash %v doesn't map to any known types or the expected type. Is it registered?", h), 0)
+		return nil, encio.NewError(ErrNotRegistered, fmt.Sprintf("received hash %x doesn't map to any known types or the expected type. Is it registered?", h), 0)
 	}
 
-	rr.put(expected, eh)
+	rr.put(expected, eh, Name(expected))
 	// Ignore errors from put; we've already suceeded in the decode (through the expected),
 	// and if it really does need to be registered now then ErrNotRegistered will be returned by a later call.
 
 	return expected, nil
 }
+
+// implicit returns true if the policy allows ty to be registered implicitly.
+func (rr *RegisterResolver) implicit(ty reflect.Type) bool {
+	switch rr.config.Policy {
+	case PolicyPermissive:
+		return true
+	case PolicyAllowPackages:
+		return rr.allowed(ty)
+	default:
+		return false
+	}
+}
+
+// allowed returns true if all the named types ty is made of are in allowed packages.
+func (rr *RegisterResolver) allowed(ty reflect.Type) bool {
+	if ty.Name() != "" {
+		pkg := ty.PkgPath()
+		if pkg == "" {
+			// predeclared
+			return true
+		}
+		for _, allowed := range rr.config.Packages {
+			if pkg == allowed || (strings.HasSuffix(allowed, "/...") && (pkg == allowed[:len(allowed)-4] || strings.HasPrefix(pkg, allowed[:len(allowed)-3]))) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch ty.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Chan:
+		return rr.allowed(ty.Elem())
+	case reflect.Map:
+		return rr.allowed(ty.Key()) && rr.allowed(ty.Elem())
+	case reflect.Struct:
+		for i := 0; i < ty.NumField(); i++ {
+			if !rr.allowed(ty.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
@@ -2,10 +2,15 @@ package encodable_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/stewi1014/encs/encio"
 	"github.com/stewi1014/encs/encodable"
 )
 
@@ -112,3 +117,455 @@ func BenchmarkRegisterResolverEncode(b *testing.B) {
 		}
 	}
 }
+
+// layoutV1 and layoutV2 return different versions of a struct with the same name.
+func layoutV1() reflect.Type {
+	type Layout struct {
+		Name string
+		Age  uint8
+	}
+	return reflect.TypeOf(Layout{})
+}
+
+func layoutV2() reflect.Type {
+	type Layout struct {
+		Name string
+		Age  uint8
+		Next *Layout
+	}
+	return reflect.TypeOf(Layout{})
+}
+
+func TestRegisterResolverStructural(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		structural bool
+		err        error
+	}{
+		{
+			desc:       "Name only",
+			structural: false,
+		},
+		{
+			desc:       "Structural",
+			structural: true,
+			err:        encodable.ErrNotRegistered,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := &encodable.RegisterConfig{Structural: tC.structural}
+			e := encodable.NewRegisterResolverWithConfig(config)
+			d := encodable.NewRegisterResolverWithConfig(config)
+
+			if err := e.Register(layoutV1()); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Register(layoutV2()); err != nil {
+				t.Fatal(err)
+			}
+
+			buff := new(bytes.Buffer)
+			if err := e.Encode(layoutV1(), buff); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := d.Decode(nil, buff)
+			if !errors.Is(err, tC.err) {
+				t.Fatalf("got error %v, want %v", err, tC.err)
+			}
+			if err == nil && decoded != layoutV2() {
+				t.Fatalf("decoded %v, want %v", decoded, layoutV2())
+			}
+		})
+	}
+
+	// builtin and recursive types must still resolve.
+	e := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{Structural: true})
+	d := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{Structural: true})
+	types := append(testTypes(), layoutV2(), reflect.TypeOf(time.Time{}))
+	for _, ty := range types {
+		e.Register(ty)
+		d.Register(ty)
+	}
+	for _, ty := range types {
+		buff := new(bytes.Buffer)
+		if err := e.Encode(ty, buff); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := d.Decode(nil, buff)
+		if err != nil {
+			t.Fatalf("error decoding %v: %v", ty, err)
+		}
+		if decoded != ty {
+			t.Fatalf("wrong type decoded, want %v but got %v", ty, decoded)
+		}
+	}
+}
+
+func TestRegisterName(t *testing.T) {
+	// e has the old version of the type, d has renamed it, keeping the old name as an alias.
+	e := encodable.NewRegisterResolver(nil)
+	d := encodable.NewRegisterResolver(nil)
+
+	if err := e.RegisterName("layout", layoutV1()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterName("layout/v2", layoutV2()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterName("layout", layoutV2()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterName("layout", layoutV2()); !errors.Is(err, encodable.ErrAlreadyRegistered) {
+		t.Fatalf("registering twice returned %v, want %v", err, encodable.ErrAlreadyRegistered)
+	}
+
+	testCases := []struct {
+		desc    string
+		encoder *encodable.RegisterResolver
+		ty      reflect.Type
+		want    reflect.Type
+	}{
+		{"Alias", e, layoutV1(), layoutV2()},
+		{"Alias slice", e, reflect.SliceOf(layoutV1()), reflect.SliceOf(layoutV2())},
+		{"Alias pointer", e, reflect.PtrTo(layoutV1()), reflect.PtrTo(layoutV2())},
+		{"Primary name", d, layoutV2(), layoutV2()},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			buff := new(bytes.Buffer)
+			if err := tC.encoder.Encode(tC.ty, buff); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := d.Decode(nil, buff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != tC.want {
+				t.Fatalf("decoded %v, want %v", decoded, tC.want)
+			}
+		})
+	}
+
+	// d encodes with the first name it was given, which e doesn't know.
+	buff := new(bytes.Buffer)
+	if err := d.Encode(layoutV2(), buff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Decode(nil, buff); !errors.Is(err, encodable.ErrNotRegistered) {
+		t.Fatalf("got error %v, want %v", err, encodable.ErrNotRegistered)
+	}
+}
+
+func TestRegisterID(t *testing.T) {
+	e := encodable.NewRegisterResolver(nil)
+	d := encodable.NewRegisterResolver(nil)
+
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := e.RegisterID(id, layoutV1()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterID(id, layoutV2()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterID(id, layoutV1()); !errors.Is(err, encodable.ErrAlreadyRegistered) {
+		t.Fatalf("registering an ID twice returned %v, want %v", err, encodable.ErrAlreadyRegistered)
+	}
+
+	buff := new(bytes.Buffer)
+	if err := e.Encode(layoutV1(), buff); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buff.Bytes(), id) {
+		t.Fatalf("encoded %v, want %v", buff.Bytes(), id)
+	}
+
+	decoded, err := d.Decode(nil, buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != layoutV2() {
+		t.Fatalf("decoded %v, want %v", decoded, layoutV2())
+	}
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	month := reflect.TypeOf(time.January)
+	testCases := []struct {
+		desc     string
+		policy   encodable.RegisterPolicy
+		packages []string
+		ty       reflect.Type
+		err      error
+	}{
+		{"Permissive", encodable.PolicyPermissive, nil, layoutV1(), nil},
+		{"Strict", encodable.PolicyStrict, nil, layoutV1(), encodable.ErrNotRegistered},
+		{"Strict predeclared", encodable.PolicyStrict, nil, reflect.TypeOf(map[string]uint{}), encodable.ErrNotRegistered},
+		{"Allowed package", encodable.PolicyAllowPackages, []string{"time"}, month, nil},
+		{"Allowed slice", encodable.PolicyAllowPackages, []string{"time"}, reflect.SliceOf(month), nil},
+		{"Allowed predeclared", encodable.PolicyAllowPackages, []string{"time"}, reflect.TypeOf(map[string][]uint{}), nil},
+		{"Disallowed package", encodable.PolicyAllowPackages, []string{"time"}, layoutV1(), encodable.ErrNotRegistered},
+		{"Disallowed map key", encodable.PolicyAllowPackages, []string{"github.com/stewi1014/encs/..."}, reflect.MapOf(month, layoutV1()), encodable.ErrNotRegistered},
+		{"Allowed sub-package", encodable.PolicyAllowPackages, []string{"github.com/stewi1014/encs/..."}, layoutV1(), nil},
+		{"Disallowed prefix", encodable.PolicyAllowPackages, []string{"github.com/stewi1014/enc/..."}, layoutV1(), encodable.ErrNotRegistered},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := &encodable.RegisterConfig{
+				Policy:   tC.policy,
+				Packages: tC.packages,
+			}
+
+			t.Run("Encode", func(t *testing.T) {
+				buff := new(bytes.Buffer)
+				err := encodable.NewRegisterResolverWithConfig(config).Encode(tC.ty, buff)
+				if !errors.Is(err, tC.err) {
+					t.Fatalf("got error %v, want %v", err, tC.err)
+				}
+				if err != nil && buff.Len() != 0 {
+					t.Fatalf("wrote %v after failing", buff.Bytes())
+				}
+			})
+
+			t.Run("Decode", func(t *testing.T) {
+				// encode with a resolver that knows the type.
+				buff := new(bytes.Buffer)
+				e := encodable.NewRegisterResolver(nil)
+				e.Register(tC.ty)
+				if err := e.Encode(tC.ty, buff); err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := encodable.NewRegisterResolverWithConfig(config).Decode(tC.ty, buff)
+				if !errors.Is(err, tC.err) {
+					t.Fatalf("got error %v, want %v", err, tC.err)
+				}
+				if err == nil && decoded != tC.ty {
+					t.Fatalf("decoded %v, want %v", decoded, tC.ty)
+				}
+			})
+		})
+	}
+}
+
+func TestRegisterResolverUnknown(t *testing.T) {
+	errLocked := errors.New("locked down")
+
+	var unknown [][]byte
+	d := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{
+		Unknown: func(id []byte, expected reflect.Type) error {
+			unknown = append(unknown, id)
+			if expected == nil {
+				return errLocked
+			}
+			return nil
+		},
+	})
+
+	e := encodable.NewRegisterResolver(nil)
+	e.Register(layoutV1())
+	buff := new(bytes.Buffer)
+	for i := 0; i < 3; i++ {
+		if err := e.Encode(layoutV1(), buff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := buff.Bytes()[:8]
+
+	if _, err := d.Decode(nil, buff); !errors.Is(err, errLocked) {
+		t.Fatalf("got error %v, want %v", err, errLocked)
+	}
+
+	// the hook allows the expected type, which is registered by the default policy.
+	if ty, err := d.Decode(layoutV1(), buff); err != nil || ty != layoutV1() {
+		t.Fatalf("decoded %v with error %v, want %v", ty, err, layoutV1())
+	}
+
+	// now registered; the hook isn't called.
+	if _, err := d.Decode(nil, buff); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unknown) != 2 || !bytes.Equal(unknown[0], id) || !bytes.Equal(unknown[1], id) {
+		t.Fatalf("hook called with %v, want %v twice", unknown, id)
+	}
+}
+
+func TestRegisterResolverIntrospection(t *testing.T) {
+	rr := encodable.NewRegisterResolver(nil)
+	if err := rr.RegisterName("layout", layoutV1()); err != nil {
+		t.Fatal(err)
+	}
+
+	types := rr.Types()
+	found := false
+	for i, ty := range types {
+		if ty == layoutV1() {
+			found = true
+		}
+		if i > 0 && encodable.Name(types[i-1]) > encodable.Name(ty) {
+			t.Errorf("types aren't sorted; %v before %v", types[i-1], ty)
+		}
+	}
+	if !found {
+		t.Fatalf("%v not in %v", layoutV1(), types)
+	}
+
+	id, ok := rr.ID(layoutV1())
+	if !ok {
+		t.Fatalf("no ID for %v", layoutV1())
+	}
+	if ty, ok := rr.Lookup(id); !ok || ty != layoutV1() {
+		t.Fatalf("Lookup(%x) returned %v, want %v", id, ty, layoutV1())
+	}
+
+	if err := rr.Unregister(layoutV1()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rr.ID(layoutV1()); ok {
+		t.Fatalf("%v still has an ID after Unregister", layoutV1())
+	}
+	if _, ok := rr.Lookup(id); ok {
+		t.Fatalf("%x still registered after Unregister", id)
+	}
+	if err := rr.Unregister(layoutV1()); !errors.Is(err, encodable.ErrNotRegistered) {
+		t.Fatalf("unregistering twice returned %v, want %v", err, encodable.ErrNotRegistered)
+	}
+}
+
+func TestRegisterResolverExport(t *testing.T) {
+	month := reflect.TypeOf(time.January)
+
+	// e and d give month different IDs.
+	e := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{Structural: true})
+	d := encodable.NewRegisterResolver(nil)
+	e.Register(month)
+	d.Register(month)
+
+	eid, _ := e.ID(month)
+	did, _ := d.ID(month)
+	if bytes.Equal(eid, did) {
+		t.Fatalf("structural and name-only IDs are both %x", eid)
+	}
+
+	table := new(bytes.Buffer)
+	if err := e.Export(table); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(table.Bytes(), []byte(fmt.Sprintf("%x time.Month\n", eid))) {
+		t.Fatalf("exported table doesn't contain %v:\n%v", month, table)
+	}
+
+	if err := d.Import(table); err != nil {
+		t.Fatal(err)
+	}
+
+	buff := new(bytes.Buffer)
+	if err := e.Encode(month, buff); err != nil {
+		t.Fatal(err)
+	}
+	if ty, err := d.Decode(nil, buff); err != nil || ty != month {
+		t.Fatalf("decoded %v with error %v, want %v", ty, err, month)
+	}
+
+	// d still encodes with its own ID.
+	if id, _ := d.ID(month); !bytes.Equal(id, did) {
+		t.Fatalf("ID changed from %x to %x after Import", did, id)
+	}
+
+	if err := d.Import(bytes.NewBufferString("not a table\n")); !errors.Is(err, encio.ErrMalformed) {
+		t.Fatalf("importing garbage returned %v, want %v", err, encio.ErrMalformed)
+	}
+}
+
+func TestRegisterResolverIDSize(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		config *encodable.RegisterConfig
+		size   int
+	}{
+		{"Default", nil, 8},
+		{"SHA-256", &encodable.RegisterConfig{Hasher: sha256.New()}, 32},
+		{"Truncated SHA-256", &encodable.RegisterConfig{Hasher: sha256.New(), IDSize: 16}, 16},
+		{"Larger than hash", &encodable.RegisterConfig{IDSize: 9}, 8},
+		{"Negative", &encodable.RegisterConfig{Hasher: sha256.New(), IDSize: -1}, 32},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e := encodable.NewRegisterResolverWithConfig(tC.config)
+			d := encodable.NewRegisterResolverWithConfig(tC.config)
+			if e.Size() != tC.size {
+				t.Fatalf("Size() returned %v, want %v", e.Size(), tC.size)
+			}
+
+			for _, ty := range testTypes() {
+				e.Register(ty)
+				d.Register(ty)
+			}
+
+			for _, ty := range testTypes() {
+				buff := new(bytes.Buffer)
+				if err := e.Encode(ty, buff); err != nil {
+					t.Fatal(err)
+				}
+				if buff.Len() != tC.size {
+					t.Fatalf("wrote %v bytes, want %v", buff.Len(), tC.size)
+				}
+
+				decoded, err := d.Decode(nil, buff)
+				if err != nil {
+					t.Fatalf("error decoding %v: %v", ty, err)
+				}
+				if decoded != ty {
+					t.Fatalf("wrong type decoded, want %v but got %v", ty, decoded)
+				}
+			}
+
+			if err := e.RegisterID(make([]byte, tC.size+1), layoutV1()); !errors.Is(err, encio.ErrBadConfig) {
+				t.Fatalf("registering a %v byte ID returned %v, want %v", tC.size+1, err, encio.ErrBadConfig)
+			}
+		})
+	}
+}
+
+type deepA struct {
+	B    deepB
+	M    map[deepKey]*deepC
+	S    [][2]deepD
+	Any  interface{}
+	Self *deepA
+}
+
+type deepB struct{ Time time.Time }
+
+type deepC struct{ Back *deepA }
+
+type deepD int
+
+type deepKey string
+
+func TestRegisterDeep(t *testing.T) {
+	rr := encodable.NewRegisterResolverWithConfig(&encodable.RegisterConfig{Policy: encodable.PolicyStrict})
+	if err := rr.RegisterDeep(deepA{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []interface{}{deepA{}, &deepA{}, []deepA{}, deepB{}, deepC{}, &deepC{}, deepD(0), []deepD{}, deepKey("")} {
+		if _, ok := rr.ID(reflect.TypeOf(v)); !ok {
+			t.Errorf("%T not registered", v)
+		}
+	}
+
+	// unnamed types along the way aren't registered.
+	for _, v := range []interface{}{map[deepKey]*deepC{}, [2]deepD{}} {
+		if _, ok := rr.ID(reflect.TypeOf(v)); ok {
+			t.Errorf("%T registered", v)
+		}
+	}
+
+	if err := rr.RegisterDeep(&deepC{}); err != nil {
+		t.Fatalf("registering registered types returned %v", err)
+	}
+}
@@ -2,7 +2,9 @@ package encodable
 
 import (
 	"encoding"
+	"fmt"
 	"reflect"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -67,17 +69,173 @@ var builtin = []interface{}{
 }
 
 // Name returns the name of the type and full package import path.
+// Unnamed types are named in Go syntax, with every type they are made of named by Name;
+// i.e. map[string]*text/template.Template, which can't be confused with map[string]*html/template.Template.
+// Unexported struct fields and interface methods are also qualified by their package.
 func Name(t reflect.Type) string {
-	if t.Kind() == reflect.Ptr {
-		return "*" + Name(t.Elem())
+	if t.Name() != "" {
+		if pkg := t.PkgPath(); pkg != "" {
+			return pkg + "." + t.Name()
+		}
+		return t.Name()
 	}
-	pkg := t.PkgPath()
-	if pkg != "" {
-		return pkg + "." + t.Name()
+
+	b := new(strings.Builder)
+	writeName(b, t)
+	return b.String()
+}
+
+// writeName writes the name of the unnamed type t.
+func writeName(b *strings.Builder, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		b.WriteString("*" + Name(t.Elem()))
+	case reflect.Slice:
+		b.WriteString("[]" + Name(t.Elem()))
+	case reflect.Array:
+		fmt.Fprintf(b, "[%v]%v", t.Len(), Name(t.Elem()))
+	case reflect.Map:
+		b.WriteString("map[" + Name(t.Key()) + "]" + Name(t.Elem()))
+	case reflect.Chan:
+		switch t.ChanDir() {
+		case reflect.RecvDir:
+			b.WriteString("<-chan ")
+		case reflect.SendDir:
+			b.WriteString("chan<- ")
+		default:
+			b.WriteString("chan ")
+		}
+		b.WriteString(Name(t.Elem()))
+	case reflect.Func:
+		b.WriteString("func")
+		writeSignature(b, t)
+	case reflect.Struct:
+		b.WriteString("struct {")
+		for i := 0; i < t.NumField(); i++ {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			f := t.Field(i)
+			b.WriteByte(' ')
+			if f.PkgPath != "" {
+				b.WriteString(f.PkgPath + ".")
+			}
+			b.WriteString(f.Name + " " + Name(f.Type))
+			if f.Tag != "" {
+				fmt.Fprintf(b, " %q", f.Tag)
+			}
+		}
+		if t.NumField() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte('}')
+	case reflect.Interface:
+		b.WriteString("interface {")
+		for i := 0; i < t.NumMethod(); i++ {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			m := t.Method(i)
+			b.WriteByte(' ')
+			if m.PkgPath != "" {
+				b.WriteString(m.PkgPath + ".")
+			}
+			b.WriteString(m.Name)
+			writeSignature(b, m.Type)
+		}
+		if t.NumMethod() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(t.String())
+	}
+}
+
+// writeSignature writes the parameters and results of the function type t.
+func writeSignature(b *strings.Builder, t reflect.Type) {
+	b.WriteByte('(')
+	for i := 0; i < t.NumIn(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			b.WriteString("..." + Name(t.In(i).Elem()))
+		} else {
+			b.WriteString(Name(t.In(i)))
+		}
+	}
+	b.WriteByte(')')
+
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		b.WriteString(" " + Name(t.Out(0)))
+	default:
+		b.WriteString(" (")
+		for i := 0; i < t.NumOut(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(Name(t.Out(i)))
+		}
+		b.WriteByte(')')
 	}
-	n := t.Name()
-	if n == "" {
-		return t.String()
+}
+
+// layout returns a description of the structure of t; field names, types and order, recursively.
+// Types implementing encoding.BinaryMarshaler and encoding.BinaryUnmarshaler are described by name alone,
+// as their structure doesn't change how they are encoded.
+func layout(t reflect.Type) string {
+	b := new(strings.Builder)
+	writeLayout(b, t, make(map[reflect.Type]bool))
+	return b.String()
+}
+
+func writeLayout(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	if t.Name() != "" {
+		b.WriteString(Name(t))
+
+		ptrt := reflect.PtrTo(t)
+		if t.PkgPath() == "" || seen[t] || (ptrt.Implements(binaryMarshalerIface) && ptrt.Implements(binaryUnmarshalerIface)) {
+			// predeclared, already described or encoded by its methods.
+			return
+		}
+
+		seen[t] = true
+		b.WriteByte(' ')
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		b.WriteByte('*')
+		writeLayout(b, t.Elem(), seen)
+	case reflect.Slice:
+		b.WriteString("[]")
+		writeLayout(b, t.Elem(), seen)
+	case reflect.Array:
+		fmt.Fprintf(b, "[%v]", t.Len())
+		writeLayout(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		writeLayout(b, t.Key(), seen)
+		b.WriteByte(']')
+		writeLayout(b, t.Elem(), seen)
+	case reflect.Struct:
+		b.WriteString("struct {")
+		for i := 0; i < t.NumField(); i++ {
+			if i > 0 {
+				b.WriteString("; ")
+			}
+			f := t.Field(i)
+			b.WriteString(f.Name + " ")
+			writeLayout(b, f.Type, seen)
+			if f.Tag != "" {
+				fmt.Fprintf(b, " %q", f.Tag)
+			}
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(t.Kind().String())
 	}
-	return n
 }
@@ -0,0 +1,73 @@
+package encodable_test
+
+import (
+	htmltemplate "html/template"
+	"reflect"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/stewi1014/encs/encodable"
+)
+
+func TestName(t *testing.T) {
+	testCases := []struct {
+		value interface{}
+		name  string
+	}{
+		{int(0), "int"},
+		{time.Time{}, "time.Time"},
+		{new(time.Time), "*time.Time"},
+		{[]int{}, "[]int"},
+		{[3][]time.Duration{}, "[3][]time.Duration"},
+		{map[string]*texttemplate.Template{}, "map[string]*text/template.Template"},
+		{map[string]*htmltemplate.Template{}, "map[string]*html/template.Template"},
+		{new(interface{}), "*interface {}"},
+		{new(error), "*error"},
+		{struct{}{}, "struct {}"},
+		{struct {
+			A       int
+			private []deepD `encs:"x"`
+		}{}, `struct { A int; github.com/stewi1014/encs/encodable_test.private []github.com/stewi1014/encs/encodable_test.deepD "encs:\"x\"" }`},
+		{make(<-chan time.Month), "<-chan time.Month"},
+		{func(int, ...deepKey) (bool, error) { return false, nil }, "func(int, ...github.com/stewi1014/encs/encodable_test.deepKey) (bool, error)"},
+		{new(interface {
+			Read([]byte) (int, error)
+			private()
+		}), "*interface { Read([]uint8) (int, error); github.com/stewi1014/encs/encodable_test.private() }"},
+	}
+	for _, tC := range testCases {
+		ty := reflect.TypeOf(tC.value)
+		t.Run(ty.String(), func(t *testing.T) {
+			if name := encodable.Name(ty); name != tC.name {
+				t.Errorf("got %v, want %v", name, tC.name)
+			}
+		})
+	}
+}
+
+func TestNameAcrossPackages(t *testing.T) {
+	text := reflect.TypeOf(map[string]*texttemplate.Template{})
+	html := reflect.TypeOf(map[string]*htmltemplate.Template{})
+	if text.String() != html.String() {
+		t.Fatalf("test is broken; reflect names %v and %v differ", text, html)
+	}
+
+	e := encodable.NewRegisterResolver(nil)
+	d := encodable.NewRegisterResolver(nil)
+	for _, rr := range []*encodable.RegisterResolver{e, d} {
+		if err := rr.Register(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := rr.Register(html); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ty := range []reflect.Type{text, html} {
+		id, _ := e.ID(ty)
+		if decoded, ok := d.Lookup(id); !ok || decoded != ty {
+			t.Errorf("%v decoded as %v", encodable.Name(ty), decoded)
+		}
+	}
+}
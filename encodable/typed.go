@@ -0,0 +1,67 @@
+package encodable
+
+import (
+	"io"
+	"reflect"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+)
+
+// For returns a TypedEncodable for the type T.
+// config is used as in New, and errors are returned as from New.
+func For[T any](config *Config) (TypedEncodable[T], error) {
+	enc, err := New(reflect.TypeOf((*T)(nil)).Elem(), config)
+	if err != nil {
+		return TypedEncodable[T]{}, err
+	}
+	return TypedEncodable[T]{
+		enc: enc,
+	}, nil
+}
+
+// TypedEncodable is a type-safe front-end to an Encodable for the type T.
+// The compiler ensures values passed to Encode and Decode are of the correct type,
+// removing the need to pass unsafe.Pointers around.
+//
+// Like Encodable, it is not thread safe.
+type TypedEncodable[T any] struct {
+	enc Encodable
+}
+
+// Encodable returns the underlying Encodable.
+func (e TypedEncodable[T]) Encodable() Encodable {
+	return e.enc
+}
+
+// Type returns the type that the TypedEncodable encodes.
+func (e TypedEncodable[T]) Type() reflect.Type {
+	return e.enc.Type()
+}
+
+// Size returns the maximum encoded size of the TypedEncodable.
+// If Size returns <0, size is undefined.
+func (e TypedEncodable[T]) Size() int {
+	return e.enc.Size()
+}
+
+// String implements fmt.Stringer
+func (e TypedEncodable[T]) String() string {
+	return e.enc.String()
+}
+
+// Encode writes the encoded form of the value at v to w.
+func (e TypedEncodable[T]) Encode(v *T, w io.Writer) error {
+	if v == nil {
+		return encio.NewError(encio.ErrNilPointer, "cannot encode nil pointer", 0)
+	}
+	return e.enc.Encode(unsafe.Pointer(v), w)
+}
+
+// Decode reads the encoded form from r into the value at v.
+func (e TypedEncodable[T]) Decode(v *T, r io.Reader) error {
+	if v == nil {
+		return encio.NewError(encio.ErrNilPointer, "cannot decode into nil pointer", 0)
+	}
+	return e.enc.Decode(unsafe.Pointer(v), r)
+}
@@ -0,0 +1,66 @@
+package encodable_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+func TestFor(t *testing.T) {
+	enc, err := encodable.For[TestStruct2](nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.Type() != reflect.TypeOf(TestStruct2{}) {
+		t.Fatalf("wrong type; got %v, want %v", enc.Type(), reflect.TypeOf(TestStruct2{}))
+	}
+
+	want := TestStruct2{
+		Name:     "John",
+		BirthDay: time.Date(2019, 10, 14, 5, 50, 20, 0, time.UTC),
+		Phone:    "7738234",
+		Siblings: 2,
+		Spouse:   true,
+		Money:    -2000,
+	}
+
+	buff := new(bytes.Buffer)
+	if err := enc.Encode(&want, buff); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	checkSize(buff, enc.Encodable(), t)
+
+	var got TestStruct2
+	if err := enc.Decode(&got, buff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("encoded %v, got %v", want, got)
+	}
+
+	if buff.Len() != 0 {
+		t.Fatalf("data remaining in buffer %v", buff.Bytes())
+	}
+}
+
+func TestForNil(t *testing.T) {
+	enc, err := encodable.For[uint32](nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.Encode(nil, new(bytes.Buffer)); !errors.Is(err, encio.ErrNilPointer) {
+		t.Errorf("encoding nil pointer; got error %v, want %v", err, encio.ErrNilPointer)
+	}
+
+	if err := enc.Decode(nil, new(bytes.Buffer)); !errors.Is(err, encio.ErrNilPointer) {
+		t.Errorf("decoding into nil pointer; got error %v, want %v", err, encio.ErrNilPointer)
+	}
+}
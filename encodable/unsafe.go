@@ -20,3 +20,10 @@ func newAt(ptr *unsafe.Pointer, t reflect.Type) {
 	new := reflect.New(t)
 	*ptr = unsafe.Pointer(new.Pointer())
 }
+
+// sliceHeader is the runtime representation of a slice.
+// Unlike reflect.SliceHeader, data is an unsafe.Pointer, so the garbage collector sees it.
+type sliceHeader struct {
+	data     unsafe.Pointer
+	len, cap int
+}
@@ -0,0 +1,290 @@
+package encodable_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+var update = flag.Bool("update", false, "update the golden test vectors in testdata")
+
+const vectorsFile = "testdata/vectors.json"
+
+// vector is a golden test vector, as stored in testdata/vectors.json.
+type vector struct {
+	// Name uniquely identifies the vector.
+	Name string `json:"name"`
+
+	// Type is the Go type of the encoded value.
+	Type string `json:"type"`
+
+	// Config is the encodable.Config used, as given by Config.String().
+	Config string `json:"config"`
+
+	// Value is the encoded value in Go syntax.
+	Value string `json:"value"`
+
+	// Hex is the expected encoded data, hex encoded.
+	Hex string `json:"hex"`
+}
+
+type vectorStruct struct {
+	Name    string
+	Age     uint8
+	Likes   []string
+	private int16
+}
+
+type vectorReferences struct {
+	A, B, C *int8
+}
+
+type vectorAliases struct {
+	A, B []int8
+	M, N map[int8]int8
+}
+
+type vectorSparse struct {
+	A, B int8
+	C    string `encs:"omitempty"`
+}
+
+type vectorFlags struct {
+	A, B bool
+	Mode int8 `encs:"bits=3"`
+}
+
+type vectorRecursive struct {
+	Next *vectorRecursive
+	N    int8
+}
+
+// wideVectors hold uints and ints that only fit on 64 bit hosts.
+// On other hosts they aren't encoded, and decoding them must return an encio.ErrOverflow error.
+var wideVectors = map[string]bool{
+	"uint/max": true,
+	"int/max":  true,
+	"int/min":  true,
+}
+
+func vectorValues() []struct {
+	name   string
+	config *encodable.Config
+	value  interface{} // pointer to the encoded value
+	desc   string      // describes the value if %#v would print pointer addresses
+} {
+	resolver := encodable.NewRegisterResolver(nil)
+
+	shared := int8(5)
+	recursive := &vectorRecursive{N: 1}
+	recursive.Next = recursive
+
+	// not constants, so the package builds on 32 bit hosts; see wideVectors.
+	maxUint64, maxInt64, minInt64 := uint64(math.MaxUint64), int64(math.MaxInt64), int64(math.MinInt64)
+
+	backing := []int8{1, 2, 3}
+	aliasedMap := map[int8]int8{1: 2}
+
+	ptr := func(v interface{}) interface{} {
+		p := reflect.New(reflect.TypeOf(v))
+		p.Elem().Set(reflect.ValueOf(v))
+		return p.Interface()
+	}
+
+	return []struct {
+		name   string
+		config *encodable.Config
+		value  interface{}
+		desc   string
+	}{
+		{"uint8", nil, ptr(uint8(0xab)), ""},
+		{"uint16", nil, ptr(uint16(0x0102)), ""},
+		{"uint32", nil, ptr(uint32(0x01020304)), ""},
+		{"uint64", nil, ptr(uint64(0x0102030405060708)), ""},
+		{"uint/single", nil, ptr(uint(247)), ""},
+		{"uint/one byte", nil, ptr(uint(248)), ""},
+		{"uint/two bytes", nil, ptr(uint(0x0102)), ""},
+		{"uint/max", nil, ptr(uint(maxUint64)), ""},
+		{"uintptr", nil, ptr(uintptr(0x010203)), ""},
+		{"int8", nil, ptr(int8(-2)), ""},
+		{"int16", nil, ptr(int16(-0x0102)), ""},
+		{"int32", nil, ptr(int32(-0x01020304)), ""},
+		{"int64", nil, ptr(int64(-0x0102030405060708)), ""},
+		{"int/single max", nil, ptr(int(127)), ""},
+		{"int/single min", nil, ptr(int(-119)), ""},
+		{"int/one byte negative", nil, ptr(int(-120)), ""},
+		{"int/two bytes positive", nil, ptr(int(128)), ""},
+		{"int/two bytes negative", nil, ptr(int(-200)), ""},
+		{"int/max", nil, ptr(int(maxInt64)), ""},
+		{"int/min", nil, ptr(int(minInt64)), ""},
+		{"float32", nil, ptr(float32(1.5)), ""},
+		{"float64", nil, ptr(float64(-1.2345678)), ""},
+		{"complex64", nil, ptr(complex64(1.5 - 2i)), ""},
+		{"complex128", nil, ptr(complex128(-1.2345678 + 2.5i)), ""},
+		{"bool/true", nil, ptr(true), ""},
+		{"bool/false", nil, ptr(false), ""},
+		{"string/empty", nil, ptr(""), ""},
+		{"string", nil, ptr("hello"), ""},
+		{"string/long", nil, ptr(strings.Repeat("encs", 100)), ""},
+		{"binarymarshaler", nil, ptr(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)), ""},
+		{"struct", nil, ptr(vectorStruct{Name: "John", Age: 30, Likes: []string{"Go"}, private: 1}), ""},
+		{"struct/unexported", &encodable.Config{IncludeUnexported: true}, ptr(vectorStruct{Name: "John", Age: 30, private: 1}), ""},
+		{"array", nil, ptr([3]uint16{1, 2, 3}), ""},
+		{"slice/nil", nil, ptr([]int8(nil)), ""},
+		{"slice", nil, ptr([]int8{1, -1}), ""},
+		{"map/empty", nil, ptr(map[string]bool{}), ""},
+		{"map", nil, ptr(map[string]bool{"a": true}), ""},
+		{"map/canonical", &encodable.Config{Canonical: true}, ptr(map[string]int8{"b": 2, "a": 1, "c": 3}), ""},
+		{"pointer/nil", nil, ptr((*int8)(nil)), ""},
+		{"pointer", nil, ptr(&shared), "&5"},
+		{"pointer/references", nil, ptr(vectorReferences{A: &shared, B: nil, C: &shared}), "A and C point to the same int8(5), B is nil"},
+		{"pointer/references in slice", nil, ptr([]*int8{&shared, &shared}), "both elements point to the same int8(5)"},
+		{"pointer/recursive", nil, ptr(recursive), "v := &vectorRecursive{N: 1}; v.Next = v"},
+		{"interface/nil", &encodable.Config{Resolver: resolver}, ptr([]interface{}{nil}), ""},
+		{"interface", &encodable.Config{Resolver: resolver}, ptr([]interface{}{int8(5), "hello"}), ""},
+		{"pointer/tree-shaped", &encodable.Config{TreeShaped: true}, ptr(vectorReferences{A: &shared, B: nil, C: &shared}), "A and C point to the same int8(5), which is written twice; B is nil"},
+		{"slice/aliased", &encodable.Config{Aliasing: true}, ptr(vectorAliases{A: backing[:2], B: backing[1:], M: aliasedMap, N: aliasedMap}), "A is []int8{1, 2, 3}[:2], B is [1:] of the same array, M and N are the same map[int8]int8{1: 2}"},
+		{"slice/aliased nil", &encodable.Config{Aliasing: true}, ptr([]int8(nil)), ""},
+		{"struct/omitempty", nil, ptr(vectorSparse{A: 1}), ""},
+		{"struct/omit zero", &encodable.Config{OmitZero: true}, ptr(vectorSparse{B: 2, C: "c"}), ""},
+		{"struct/bits", nil, ptr(vectorFlags{A: true, Mode: -2}), ""},
+		{"struct/packed", &encodable.Config{PackBits: true}, ptr(vectorFlags{B: true, Mode: 3}), ""},
+		{"array/packed", &encodable.Config{PackBits: true}, ptr([9]bool{0: true, 8: true}), ""},
+		{"slice/packed", &encodable.Config{PackBits: true}, ptr([]bool{false, true, true}), ""},
+		{"interface/tree-shaped", &encodable.Config{Resolver: resolver, TreeShaped: true}, ptr([]interface{}{int8(5), nil}), ""},
+	}
+}
+
+func TestVectors(t *testing.T) {
+	var golden []vector
+	if !*update {
+		data, err := os.ReadFile(vectorsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &golden); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	byName := make(map[string]vector)
+	for _, v := range golden {
+		byName[v.Name] = v
+	}
+
+	var generated []vector
+	for _, tC := range vectorValues() {
+		t.Run(tC.name, func(t *testing.T) {
+			val := reflect.ValueOf(tC.value)
+			ty := val.Type().Elem()
+			enc := encodable.MustNew(ty, tC.config)
+
+			if wideVectors[tC.name] && bits.UintSize < 64 {
+				testWideVector(t, enc, byName[tC.name])
+				delete(byName, tC.name)
+				return
+			}
+
+			buff := new(bytes.Buffer)
+			if err := enc.Encode(unsafe.Pointer(val.Pointer()), buff); err != nil {
+				t.Fatalf("encode error: %v", err)
+			}
+
+			desc := tC.desc
+			if desc == "" {
+				desc = fmt.Sprintf("%#v", val.Elem())
+			}
+
+			generated = append(generated, vector{
+				Name:   tC.name,
+				Type:   ty.String(),
+				Config: tC.config.String(),
+				Value:  desc,
+				Hex:    hex.EncodeToString(buff.Bytes()),
+			})
+
+			if *update {
+				return
+			}
+
+			want, ok := byName[tC.name]
+			if !ok {
+				t.Fatalf("no golden vector; run go test with -update")
+			}
+			delete(byName, tC.name)
+
+			if want.Type != ty.String() || want.Config != tC.config.String() {
+				t.Fatalf("golden vector is for %v with %v, but test value is %v with %v", want.Type, want.Config, ty, tC.config)
+			}
+
+			if got := hex.EncodeToString(buff.Bytes()); got != want.Hex {
+				t.Fatalf("encoded %v, want %v", got, want.Hex)
+			}
+
+			data, err := hex.DecodeString(want.Hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := reflect.New(ty)
+			r := bytes.NewReader(data)
+			if err := enc.Decode(unsafe.Pointer(decoded.Pointer()), r); err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+
+			if r.Len() != 0 {
+				t.Fatalf("%v bytes remaining after decode", r.Len())
+			}
+
+			if diffs := encodable.Diff(enc, unsafe.Pointer(val.Pointer()), unsafe.Pointer(decoded.Pointer())); diffs != nil {
+				t.Fatalf("decoded value differs: %v", diffs)
+			}
+		})
+	}
+
+	if *update {
+		data, err := json.MarshalIndent(generated, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	for name := range byName {
+		t.Errorf("golden vector %v has no test value", name)
+	}
+}
+
+// testWideVector checks that decoding a vector in wideVectors returns an encio.ErrOverflow error.
+func testWideVector(t *testing.T, enc encodable.Encodable, want vector) {
+	if *update {
+		t.Fatal("golden vectors must be updated on a 64 bit host")
+	}
+
+	data, err := hex.DecodeString(want.Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := reflect.New(enc.Type())
+	if err := enc.Decode(unsafe.Pointer(decoded.Pointer()), bytes.NewReader(data)); !errors.Is(err, encio.ErrOverflow) {
+		t.Fatalf("decoding %v; got error %v, want %v", want.Hex, err, encio.ErrOverflow)
+	}
+}
@@ -11,19 +11,55 @@ import (
 
 func NewEncoder(w io.Writer, config *Config) *Encoder {
 	config = config.copyAndFill()
-	return &Encoder{
-		w:        w,
-		resolver: config.Resolver,
-		source: encodable.NewSource(&encodable.Config{
-			Resolver: config.Resolver,
-		}, encodable.New),
+	ec := config.encodableConfig()
+	e := &Encoder{
+		w:          w,
+		resolver:   config.Resolver,
+		source:     encodable.NewSource(ec, encodable.New),
+		references: ec.References,
+	}
+	if config.Header {
+		e.header = config.header()
+	}
+	if config.Fingerprint {
+		e.fingerprints = newTypeFingerprints(config)
+	}
+	if config.Delta {
+		e.previous = make(map[reflect.Type]reflect.Value)
 	}
+	return e
 }
 
 type Encoder struct {
 	w        io.Writer
 	resolver encodable.Resolver
 	source   *encodable.Source
+
+	// header is the stream header, or nil once it has been written.
+	header []byte
+
+	// fingerprints is nil unless Config.Fingerprint is set.
+	fingerprints *typeFingerprints
+
+	// references is nil unless Config.PersistReferences is set.
+	references *encodable.References
+
+	// previous is nil unless Config.Delta is set.
+	// It holds a copy of the last value of each type that was sent.
+	previous map[reflect.Type]reflect.Value
+}
+
+// Reset empties the reference table kept with Config.PersistReferences, and forgets the values kept with Config.Delta,
+// so values are sent again in full.
+// The Decoder must be Reset before it decodes the next message.
+// It does nothing if neither PersistReferences nor Delta are set.
+func (e *Encoder) Reset() {
+	if e.references != nil {
+		e.references.Reset()
+	}
+	if e.previous != nil {
+		e.previous = make(map[reflect.Type]reflect.Value)
+	}
 }
 
 func (e *Encoder) Encode(v interface{}) error {
@@ -36,18 +72,68 @@ func (e *Encoder) Encode(v interface{}) error {
 		return encio.NewError(encio.ErrBadType, "values must be passed by reference", 0)
 	}
 
-	t = t.Elem() // the encoded type
+	// we've already confirmed that the interface contains a pointer type,
+	// elem should be a pointer to the actual value, not a pointer; the pointer type seems to be stored internally in the interface,
+	// so we take just take the address and go.
+	return e.encode(t.Elem(), ptrInterface(unsafe.Pointer(&v)).elem)
+}
+
+// encode writes the type t and the value of type t at ptr.
+// ptr must be a valid pointer to a value of type t.
+func (e *Encoder) encode(t reflect.Type, ptr unsafe.Pointer) error {
+	if e.header != nil {
+		if err := encio.Write(e.header, e.w); err != nil {
+			return err
+		}
+		e.header = nil
+	}
 
-	err := e.resolver.Encode(t, e.w)
+	enc, err := e.source.GetEncodable(t)
 	if err != nil {
 		return err
 	}
 
-	ec := e.source.GetEncodable(t)
-	// we've already confirmed that the interface contains a pointer type,
-	// elem should be a pointer to the actual value, not a pointer; the pointer type seems to be stored internally in the interface,
-	// so we take just take the address and go.
-	return ec.Encode(ptrInterface(unsafe.Pointer(&v)).elem, e.w)
+	if err := e.resolver.Encode(t, e.w); err != nil {
+		return err
+	}
+
+	if e.fingerprints != nil {
+		fp, err := e.fingerprints.get(t)
+		if err != nil {
+			return err
+		}
+		if err := encio.Write(fp[:], e.w); err != nil {
+			return err
+		}
+	}
+
+	if e.previous == nil {
+		return enc.Encode(ptr, e.w)
+	}
+	return e.encodeDelta(t, enc, ptr)
+}
+
+// encodeDelta writes the value at ptr as the changes from the last value of type t, or in full if it is the first,
+// and keeps a copy of it.
+func (e *Encoder) encodeDelta(t reflect.Type, enc encodable.Encodable, ptr unsafe.Pointer) error {
+	prev, ok := e.previous[t]
+	if ok {
+		if err := encodable.EncodeDelta(enc, unsafe.Pointer(prev.Pointer()), ptr, e.w); err != nil {
+			return err
+		}
+	} else {
+		if err := enc.Encode(ptr, e.w); err != nil {
+			return err
+		}
+		prev = reflect.New(t)
+		e.previous[t] = prev
+	}
+
+	if err := encodable.Clone(enc, unsafe.Pointer(prev.Pointer()), ptr); err != nil {
+		delete(e.previous, t)
+		return err
+	}
+	return nil
 }
 
 /*
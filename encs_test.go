@@ -14,7 +14,7 @@ type ExampleStruct struct {
 	Birthday time.Time
 }
 
-func ExampleEncodeDecode() {
+func Example_encodeDecode() {
 	buff := new(bytes.Buffer)
 
 	// This would typically go in an init() function.
@@ -53,3 +53,59 @@ func ExampleEncodeDecode() {
 	// Output:
 	// Name: John Doe, Likes: [Computers Music], Birthday: 2006-01-02 15:04:05 +0000 UTC
 }
+
+func Example_typed() {
+	buff := new(bytes.Buffer)
+
+	// This would typically go in an init() function.
+	encs.Register(ExampleStruct{})
+
+	enc := encs.NewTypedEncoder[ExampleStruct](buff, nil)
+
+	err := enc.Encode(&ExampleStruct{
+		Name:  "Jane Doe",
+		Likes: []string{"Go"},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	dec := encs.NewTypedDecoder[ExampleStruct](buff, nil)
+
+	var decodedExample ExampleStruct
+	err = dec.Decode(&decodedExample)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Name: %v, Likes: %v", decodedExample.Name, decodedExample.Likes)
+
+	// Output:
+	// Name: Jane Doe, Likes: [Go]
+}
+
+func Example_copy() {
+	original := ExampleStruct{
+		Name: "John Doe",
+		Likes: []string{
+			"Computers",
+			"Music",
+		},
+	}
+
+	var copied ExampleStruct
+	err := encs.Copy(&copied, &original)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	original.Likes[1] = "Art"
+
+	fmt.Printf("Name: %v, Likes: %v", copied.Name, copied.Likes)
+
+	// Output:
+	// Name: John Doe, Likes: [Computers Music]
+}
@@ -1,3 +1,3 @@
 module github.com/stewi1014/encs
 
-go 1.13
+go 1.18
@@ -0,0 +1,131 @@
+package encs
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"reflect"
+
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+// Version is the version of the encs wire format.
+// It is written in stream headers, and is incremented whenever a change to encs changes the encoded form of values.
+const Version = 5
+
+// headerMagic starts every stream header.
+var headerMagic = [4]byte{'e', 'n', 'c', 's'}
+
+var crcTable = crc64.MakeTable(crc64.ISO)
+
+// fingerprint returns the little-endian bytes of the CRC-64 of str.
+func fingerprint(str string) [8]byte {
+	h := crc64.Checksum([]byte(str), crcTable)
+
+	var out [8]byte
+	for i := range out {
+		out[i] = uint8(h >> (i * 8))
+	}
+	return out
+}
+
+// wireConfig returns the encodable.Config with options that don't change how data is decoded cleared;
+// that is, the parts of config that must be the same for Encoder and Decoder.
+func (c *Config) wireConfig() *encodable.Config {
+	ec := c.encodableConfig()
+
+	// Canonical data decodes the same with or without Canonical set.
+	ec.Canonical = false
+
+	// Merge only changes what is done with decoded values.
+	ec.Merge = false
+
+	return ec
+}
+
+// fingerprint returns a hash identifying the parts of config that must be the same for Encoder and Decoder.
+func (c *Config) fingerprint() [8]byte {
+	str := c.wireConfig().String()
+	if c.Delta {
+		str += " Delta"
+	}
+	if c.Fingerprint {
+		str += " Fingerprint"
+	}
+	return fingerprint(str)
+}
+
+// typeFingerprints caches fingerprints of the Encodables for types.
+type typeFingerprints struct {
+	config *encodable.Config
+	cache  map[reflect.Type][8]byte
+}
+
+func newTypeFingerprints(config *Config) *typeFingerprints {
+	return &typeFingerprints{
+		config: config.wireConfig(),
+		cache:  make(map[reflect.Type][8]byte),
+	}
+}
+
+// get returns the fingerprint for t; a hash of the String of its Encodable,
+// which changes with the Config and with the layout of t.
+func (f *typeFingerprints) get(t reflect.Type) ([8]byte, error) {
+	if fp, ok := f.cache[t]; ok {
+		return fp, nil
+	}
+
+	enc, err := encodable.New(t, f.config)
+	if err != nil {
+		return [8]byte{}, err
+	}
+
+	fp := fingerprint(enc.String())
+	f.cache[t] = fp
+	return fp, nil
+}
+
+// header returns the stream header for config.
+func (c *Config) header() []byte {
+	buff := new(bytes.Buffer)
+	buff.Write(headerMagic[:])
+
+	var version encio.Uvarint
+	version.Encode(buff, Version) // writing to a bytes.Buffer can't fail.
+
+	fingerprint := c.fingerprint()
+	buff.Write(fingerprint[:])
+	return buff.Bytes()
+}
+
+// readHeader reads a stream header from r, checking that it was written by an Encoder with a compatible Config.
+func readHeader(r io.Reader, config *Config) error {
+	var magic [len(headerMagic)]byte
+	if err := encio.Read(magic[:], r); err != nil {
+		return err
+	}
+	if magic != headerMagic {
+		return encio.NewIOError(encio.ErrMalformed, r, fmt.Sprintf("stream header starts with %x, not %x", magic, headerMagic), 0)
+	}
+
+	var vbuff encio.Uvarint
+	version, err := vbuff.Decode(r)
+	if err != nil {
+		return err
+	}
+	if version != Version {
+		return encio.NewError(encio.ErrBadVersion, fmt.Sprintf("stream was written with version %v, but this is version %v", version, Version), 0)
+	}
+
+	var fingerprint [8]byte
+	if err := encio.Read(fingerprint[:], r); err != nil {
+		return err
+	}
+	if want := config.fingerprint(); fingerprint != want {
+		return encio.NewError(encio.ErrBadConfig, fmt.Sprintf("stream config fingerprint is %x, but %v has fingerprint %x", fingerprint, config.encodableConfig(), want), 0)
+	}
+
+	return nil
+}
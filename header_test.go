@@ -0,0 +1,166 @@
+package encs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stewi1014/encs"
+	"github.com/stewi1014/encs/encio"
+	"github.com/stewi1014/encs/encodable"
+)
+
+func encodeWithHeader(t *testing.T, config *encs.Config) []byte {
+	buff := new(bytes.Buffer)
+	enc := encs.NewEncoder(buff, config)
+	for _, s := range []string{"first", "second"} {
+		if err := enc.Encode(&s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buff.Bytes()
+}
+
+func TestHeader(t *testing.T) {
+	header := &encs.Config{Header: true}
+
+	testCases := []struct {
+		desc    string
+		encoder *encs.Config
+		decoder *encs.Config
+		corrupt func(data []byte)
+		err     error
+	}{
+		{
+			desc:    "Matching config",
+			encoder: header,
+			decoder: header,
+		},
+		{
+			desc:    "Canonical encoder",
+			encoder: &encs.Config{Header: true, Canonical: true},
+			decoder: header,
+		},
+		{
+			desc:    "Merging decoder",
+			encoder: header,
+			decoder: &encs.Config{Header: true, Merge: true},
+		},
+		{
+			desc:    "Fingerprinting encoder",
+			encoder: &encs.Config{Header: true, Fingerprint: true},
+			decoder: header,
+			err:     encio.ErrBadConfig,
+		},
+		{
+			desc:    "No header",
+			encoder: nil,
+			decoder: header,
+			err:     encio.ErrMalformed,
+		},
+		{
+			desc:    "Different version",
+			encoder: header,
+			decoder: header,
+			corrupt: func(data []byte) { data[4]++ },
+			err:     encio.ErrBadVersion,
+		},
+		{
+			desc:    "Different config",
+			encoder: header,
+			decoder: header,
+			corrupt: func(data []byte) { data[5]++ },
+			err:     encio.ErrBadConfig,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			data := encodeWithHeader(t, tC.encoder)
+			if tC.corrupt != nil {
+				tC.corrupt(data)
+			}
+
+			dec := encs.NewDecoder(bytes.NewReader(data), tC.decoder)
+			for _, want := range []string{"first", "second"} {
+				var got string
+				err := dec.Decode(&got)
+				if tC.err != nil {
+					if !errors.Is(err, tC.err) {
+						t.Fatalf("got error %v, want %v", err, tC.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatalf("decoded %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+type fingerprintStruct struct {
+	Name   string
+	secret int
+}
+
+func TestFingerprint(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	if err := resolver.Register(fingerprintStruct{}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc    string
+		encoder *encs.Config
+		decoder *encs.Config
+		err     error
+	}{
+		{
+			desc:    "Matching config",
+			encoder: &encs.Config{Resolver: resolver, Fingerprint: true},
+			decoder: &encs.Config{Resolver: resolver, Fingerprint: true},
+		},
+		{
+			desc:    "Canonical encoder",
+			encoder: &encs.Config{Resolver: resolver, Fingerprint: true, Canonical: true},
+			decoder: &encs.Config{Resolver: resolver, Fingerprint: true},
+		},
+		{
+			desc:    "Different IncludeUnexported",
+			encoder: &encs.Config{Resolver: resolver, Fingerprint: true, IncludeUnexported: true},
+			decoder: &encs.Config{Resolver: resolver, Fingerprint: true},
+			err:     encio.ErrBadConfig,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			buff := new(bytes.Buffer)
+			enc := encs.NewEncoder(buff, tC.encoder)
+			dec := encs.NewDecoder(buff, tC.decoder)
+
+			for _, name := range []string{"John", "Jane"} {
+				if err := enc.Encode(&fingerprintStruct{Name: name, secret: 1}); err != nil {
+					t.Fatal(err)
+				}
+
+				var got fingerprintStruct
+				err := dec.Decode(&got)
+				if tC.err != nil {
+					if !errors.Is(err, tC.err) {
+						t.Fatalf("got error %v, want %v", err, tC.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Name != name {
+					t.Fatalf("decoded %q, want %q", got.Name, name)
+				}
+			}
+		})
+	}
+}
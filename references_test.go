@@ -0,0 +1,99 @@
+package encs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stewi1014/encs"
+	"github.com/stewi1014/encs/encodable"
+)
+
+type referencesNode struct {
+	Name string
+	Next *referencesNode
+}
+
+func TestPersistReferences(t *testing.T) {
+	resolver := encodable.NewRegisterResolver(nil)
+	config := &encs.Config{Resolver: resolver, PersistReferences: true, ReferenceLimit: 3}
+
+	buff := new(bytes.Buffer)
+	enc := encs.NewEncoder(buff, config)
+	dec := encs.NewDecoder(buff, config)
+
+	first := &referencesNode{Name: "first"}
+	if err := enc.Encode(&first); err != nil {
+		t.Fatal(err)
+	}
+	full := buff.Len()
+
+	var decodedFirst *referencesNode
+	if err := dec.Decode(&decodedFirst); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pointer to a value sent in an earlier message is sent as a reference.
+	second := &referencesNode{Name: "second", Next: first}
+	if err := enc.Encode(&second); err != nil {
+		t.Fatal(err)
+	}
+	var decodedSecond *referencesNode
+	if err := dec.Decode(&decodedSecond); err != nil {
+		t.Fatal(err)
+	}
+	if decodedSecond.Next != decodedFirst {
+		t.Errorf("second.Next decoded as %v, not the first decoded node", decodedSecond.Next)
+	}
+
+	// values are only sent once.
+	if err := enc.Encode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if buff.Len() >= full {
+		t.Errorf("re-sending first took %v bytes, which is not less than the %v bytes it took the first time", buff.Len(), full)
+	}
+	var decoded *referencesNode
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != decodedFirst {
+		t.Errorf("re-sent first decoded as %v, not the first decoded node", decoded)
+	}
+
+	// after Reset, values are sent and decoded again.
+	enc.Reset()
+	dec.Reset()
+	if err := enc.Encode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded == decodedSecond || decoded.Next == decodedFirst || decoded.Next.Name != "first" {
+		t.Errorf("decoded %v after Reset, want a new copy of second", decoded)
+	}
+
+	// the table now holds 2 nodes, and 2 more nodes takes it over the limit of 3, so it is emptied before the next message.
+	third := &referencesNode{Name: "third", Next: &referencesNode{Name: "fourth"}}
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(&third); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded == decodedFirst || decoded.Name != "first" {
+		t.Errorf("decoded %v after the limit was reached, want a new copy of first", decoded)
+	}
+
+	if buff.Len() != 0 {
+		t.Errorf("%v bytes remaining after decoding", buff.Len())
+	}
+}
@@ -11,3 +11,22 @@ var DefaultResolver = encodable.NewRegisterResolver(nil)
 func Register(t interface{}) error {
 	return DefaultResolver.Register(t)
 }
+
+// RegisterName registers the type of t under name.
+// It is a shortcut for DefaultResolver.RegisterName()
+func RegisterName(name string, t interface{}) error {
+	return DefaultResolver.RegisterName(name, t)
+}
+
+// RegisterID registers the type of t with the given ID.
+// It is a shortcut for DefaultResolver.RegisterID()
+func RegisterID(id []byte, t interface{}) error {
+	return DefaultResolver.RegisterID(id, t)
+}
+
+// RegisterDeep registers the type of t, and every named type reachable from it;
+// the types of its fields, elements, keys and values, recursively.
+// It is a shortcut for DefaultResolver.RegisterDeep()
+func RegisterDeep(t interface{}) error {
+	return DefaultResolver.RegisterDeep(t)
+}
@@ -0,0 +1,55 @@
+package encs
+
+import (
+	"io"
+	"reflect"
+	"unsafe"
+
+	"github.com/stewi1014/encs/encio"
+)
+
+// NewTypedEncoder returns a new TypedEncoder for values of type T, writing to w.
+func NewTypedEncoder[T any](w io.Writer, config *Config) *TypedEncoder[T] {
+	return &TypedEncoder[T]{
+		enc: NewEncoder(w, config),
+		t:   reflect.TypeOf((*T)(nil)).Elem(),
+	}
+}
+
+// TypedEncoder is an Encoder for a single type T.
+// It writes the same data as Encoder, so values it encodes can be decoded by either Decoder or TypedDecoder.
+type TypedEncoder[T any] struct {
+	enc *Encoder
+	t   reflect.Type
+}
+
+// Encode encodes the value at v.
+func (e *TypedEncoder[T]) Encode(v *T) error {
+	if v == nil {
+		return encio.NewError(encio.ErrNilPointer, "cannot encode nil pointer", 0)
+	}
+	return e.enc.encode(e.t, unsafe.Pointer(v))
+}
+
+// NewTypedDecoder returns a new TypedDecoder for values of type T, reading from r.
+func NewTypedDecoder[T any](r io.Reader, config *Config) *TypedDecoder[T] {
+	return &TypedDecoder[T]{
+		dec: NewDecoder(r, config),
+		t:   reflect.TypeOf((*T)(nil)).Elem(),
+	}
+}
+
+// TypedDecoder is a Decoder for a single type T.
+// Received values of any other type return an encio.ErrBadType error.
+type TypedDecoder[T any] struct {
+	dec *Decoder
+	t   reflect.Type
+}
+
+// Decode decodes the next value into v.
+func (d *TypedDecoder[T]) Decode(v *T) error {
+	if v == nil {
+		return encio.NewError(encio.ErrNilPointer, "cannot decode into nil pointer", 0)
+	}
+	return d.dec.decode(d.t, unsafe.Pointer(v))
+}